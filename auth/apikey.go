@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/common"
+)
+
+// Scopes granted to API keys. The admin scope grants all the others.
+const (
+	ScopeRecordsWrite = "records:write"
+	ScopeStatsRead    = "stats:read"
+	ScopeAdmin        = "admin"
+)
+
+var Scopes = []string{ScopeRecordsWrite, ScopeStatsRead, ScopeAdmin}
+
+// keyPrefix marks the tokens of the service, e.g. for secret scanners
+const keyPrefix = "nba_"
+
+var (
+	ErrInvalidKey  = errors.New("invalid API key")
+	ErrKeyNotFound = errors.New("API key not found")
+)
+
+type Database interface {
+	Exec(query string, args ...interface{}) error
+	Query(query string, args ...interface{}) (common.Rows, error)
+}
+
+type Key struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	Requests   int64      `json:"requests"`
+}
+
+// HasScope reports whether the key grants the scope
+func (k *Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// IssuedKey is a new key with its token, which is shown only once as only its hash is stored
+type IssuedKey struct {
+	Key
+	Token string `json:"token"`
+}
+
+type usage struct {
+	requests   int64
+	lastUsedAt time.Time
+}
+
+// maxCachedKeys bounds the authenticated keys kept in process
+const maxCachedKeys = 10000
+
+type cachedKey struct {
+	key       Key
+	expiresAt time.Time
+}
+
+// Store keeps the API keys in the database, hashed with SHA-256: the tokens are random,
+// so unlike passwords they need no slow hash. The usage of the keys is counted in process
+// and added to the database periodically, so requests do not write to it.
+// Authenticated keys are kept in process for cacheTTL, so requests do not read it either;
+// a key revoked on another pod is accepted there until its cached copy expires.
+type Store struct {
+	db       Database
+	cacheTTL time.Duration
+
+	mu      sync.Mutex
+	pending map[int]usage
+	cached  map[string]cachedKey
+}
+
+// NewStore returns the store of the keys in db, caching authenticated keys for cacheTTL, or not at all if it is 0
+func NewStore(db Database, cacheTTL time.Duration) *Store {
+	return &Store{db: db, cacheTTL: cacheTTL, pending: make(map[int]usage), cached: make(map[string]cachedKey)}
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// validate checks the name and the scopes of a new key, returning the scopes without duplicates
+func validate(name string, scopes []string) ([]string, error) {
+	if name == "" || len(name) > 100 {
+		return nil, fmt.Errorf("name must be between 1 and 100 characters")
+	}
+	var valid []string
+	seen := make(map[string]bool)
+	for _, scope := range scopes {
+		if seen[scope] {
+			continue
+		}
+		known := false
+		for _, s := range Scopes {
+			known = known || s == scope
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid scope %q, expected one of %v", scope, Scopes)
+		}
+		seen[scope] = true
+		valid = append(valid, scope)
+	}
+	if len(valid) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return valid, nil
+}
+
+// Issue creates a key with the scopes and returns it with its token
+func (s *Store) Issue(name string, scopes []string) (*IssuedKey, error) {
+	scopes, err := validate(name, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	issued := &IssuedKey{Key: Key{Name: name, Scopes: scopes}, Token: keyPrefix + hex.EncodeToString(secret)}
+
+	rows, err := s.db.Query("INSERT INTO api_keys (name, key_hash, scopes) VALUES ($1, $2, $3) RETURNING id, created_at",
+		name, hashToken(issued.Token), scopes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, fmt.Errorf("API key was not created")
+	}
+	if err := rows.Scan(&issued.ID, &issued.CreatedAt); err != nil {
+		return nil, err
+	}
+	return issued, rows.Err()
+}
+
+// Revoke revokes the key, so its token is rejected from then on
+func (s *Store) Revoke(id int) error {
+	rows, err := s.db.Query("UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL RETURNING id", id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	s.mu.Lock()
+	for hash, cached := range s.cached {
+		if cached.key.ID == id {
+			delete(s.cached, hash)
+		}
+	}
+	s.mu.Unlock()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return ErrKeyNotFound
+	}
+	return rows.Err()
+}
+
+// List returns all the keys, revoked ones included, with their usage
+func (s *Store) List() ([]Key, error) {
+	rows, err := s.db.Query("SELECT id, name, scopes, created_at, revoked_at, last_used_at, requests FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []Key{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for rows.Next() {
+		var key Key
+		if err := rows.Scan(&key.ID, &key.Name, &key.Scopes, &key.CreatedAt, &key.RevokedAt, &key.LastUsedAt, &key.Requests); err != nil {
+			return nil, err
+		}
+		// Include the usage not added to the database yet
+		if u, ok := s.pending[key.ID]; ok {
+			key.Requests += u.requests
+			if key.LastUsedAt == nil || u.lastUsedAt.After(*key.LastUsedAt) {
+				lastUsedAt := u.lastUsedAt
+				key.LastUsedAt = &lastUsedAt
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Authenticate returns the key of the token and counts its use, or ErrInvalidKey if it is unknown or revoked
+func (s *Store) Authenticate(token string) (*Key, error) {
+	hash := hashToken(token)
+	key, err := s.lookup(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	u := s.pending[key.ID]
+	u.requests++
+	u.lastUsedAt = time.Now()
+	s.pending[key.ID] = u
+	s.mu.Unlock()
+	return key, nil
+}
+
+// lookup returns the key with the hash from the process cache, or reads it from the database and caches it.
+// Unknown tokens are not cached, so they cannot fill the cache.
+func (s *Store) lookup(hash string) (*Key, error) {
+	s.mu.Lock()
+	cached, ok := s.cached[hash]
+	if ok && time.Now().After(cached.expiresAt) {
+		delete(s.cached, hash)
+		ok = false
+	}
+	s.mu.Unlock()
+	if ok {
+		key := cached.key
+		return &key, nil
+	}
+
+	rows, err := s.db.Query("SELECT id, name, scopes, created_at FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL", hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidKey
+	}
+	var key Key
+	if err := rows.Scan(&key.ID, &key.Name, &key.Scopes, &key.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if s.cacheTTL > 0 {
+		s.mu.Lock()
+		if len(s.cached) >= maxCachedKeys {
+			s.cached = make(map[string]cachedKey)
+		}
+		s.cached[hash] = cachedKey{key: key, expiresAt: time.Now().Add(s.cacheTTL)}
+		s.mu.Unlock()
+	}
+	return &key, nil
+}
+
+// FlushUsage adds the usage counted since the last flush to the database. The usage that fails to be added is kept for the next flush.
+func (s *Store) FlushUsage() error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[int]usage)
+	s.mu.Unlock()
+
+	var firstErr error
+	for id, u := range pending {
+		err := s.db.Exec("UPDATE api_keys SET requests = requests + $2, last_used_at = GREATEST(last_used_at, $3) WHERE id = $1", id, u.requests, u.lastUsedAt)
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		s.mu.Lock()
+		current := s.pending[id]
+		current.requests += u.requests
+		if u.lastUsedAt.After(current.lastUsedAt) {
+			current.lastUsedAt = u.lastUsedAt
+		}
+		s.pending[id] = current
+		s.mu.Unlock()
+	}
+	return firstErr
+}
+
+// FlushUsagePeriodically flushes the usage every interval, and a last time when the context is done
+func (s *Store) FlushUsagePeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.FlushUsage(); err != nil {
+				log.Printf("Unable to flush API key usage: %v\n", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.FlushUsage(); err != nil {
+				log.Printf("Unable to flush API key usage: %v\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIssueAndAuthenticate(t *testing.T) {
+	db := &fakeDB{}
+	store := NewStore(db, time.Minute)
+
+	issued, err := store.Issue("scoreboard", []string{ScopeStatsRead, ScopeStatsRead, ScopeRecordsWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(issued.Token, keyPrefix) || len(issued.Token) != len(keyPrefix)+64 {
+		t.Errorf("unexpected token %s", issued.Token)
+	}
+	if !reflect.DeepEqual(issued.Scopes, []string{ScopeStatsRead, ScopeRecordsWrite}) {
+		t.Errorf("scopes = %v, expected them without duplicates", issued.Scopes)
+	}
+	if db.keys[0].hash == issued.Token || db.keys[0].hash != hashToken(issued.Token) {
+		t.Error("the token should be stored hashed")
+	}
+
+	key, err := store.Authenticate(issued.Token)
+	if err != nil || key.ID != issued.ID || !key.HasScope(ScopeRecordsWrite) || key.HasScope(ScopeAdmin) {
+		t.Errorf("Authenticate() = %+v, %v", key, err)
+	}
+	if _, err := store.Authenticate(issued.Token + "0"); err != ErrInvalidKey {
+		t.Errorf("Authenticate() error = %v for an unknown token, expected ErrInvalidKey", err)
+	}
+
+	if err := store.Revoke(issued.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Authenticate(issued.Token); err != ErrInvalidKey {
+		t.Errorf("Authenticate() error = %v for a revoked key, expected ErrInvalidKey", err)
+	}
+	if err := store.Revoke(issued.ID); err != ErrKeyNotFound {
+		t.Errorf("Revoke() error = %v for a revoked key, expected ErrKeyNotFound", err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		scopes []string
+	}{{"", []string{ScopeAdmin}}, {"no scopes", nil}, {"unknown scope", []string{"records:delete"}}} {
+		if _, err := store.Issue(test.name, test.scopes); err == nil {
+			t.Errorf("Issue(%q, %v) should fail", test.name, test.scopes)
+		}
+	}
+}
+
+func TestAdminScopeGrantsAll(t *testing.T) {
+	key := &Key{Scopes: []string{ScopeAdmin}}
+	for _, scope := range Scopes {
+		if !key.HasScope(scope) {
+			t.Errorf("admin key lacks %s", scope)
+		}
+	}
+}
+
+func TestAuthenticateCachesKeys(t *testing.T) {
+	db := &fakeDB{}
+	store := NewStore(db, time.Minute)
+	issued, _ := store.Issue("scoreboard", []string{ScopeStatsRead})
+	if _, err := store.Authenticate(issued.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	db.fail = "key_hash"
+	if key, err := store.Authenticate(issued.Token); err != nil || key.ID != issued.ID {
+		t.Errorf("Authenticate() = %+v, %v, expected the cached key", key, err)
+	}
+	if _, err := store.Authenticate(issued.Token + "0"); err == nil {
+		t.Error("unknown tokens should be read from the database")
+	}
+	if keys, _ := store.List(); keys[0].Requests != 2 {
+		t.Errorf("requests = %d, expected the cached authentications to be counted", keys[0].Requests)
+	}
+
+	store.cached[hashToken(issued.Token)] = cachedKey{key: issued.Key, expiresAt: time.Now().Add(-time.Second)}
+	if _, err := store.Authenticate(issued.Token); err == nil {
+		t.Error("expired keys should be read from the database")
+	}
+
+	db.fail = ""
+	store.Authenticate(issued.Token)
+	if err := store.Revoke(issued.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Authenticate(issued.Token); err != ErrInvalidKey {
+		t.Errorf("Authenticate() error = %v for a key revoked by this store, expected ErrInvalidKey", err)
+	}
+}
+
+func TestUsageTracking(t *testing.T) {
+	db := &fakeDB{}
+	store := NewStore(db, time.Minute)
+	first, _ := store.Issue("first", []string{ScopeStatsRead})
+	second, _ := store.Issue("second", []string{ScopeStatsRead})
+	for i := 0; i < 3; i++ {
+		store.Authenticate(first.Token)
+	}
+	store.Authenticate(second.Token)
+
+	requests := func() []int64 {
+		keys, err := store.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var requests []int64
+		for _, key := range keys {
+			if key.LastUsedAt == nil {
+				t.Errorf("key %d has no last use", key.ID)
+			}
+			requests = append(requests, key.Requests)
+		}
+		return requests
+	}
+	if got := requests(); !reflect.DeepEqual(got, []int64{3, 1}) {
+		t.Errorf("requests before the flush = %v, expected the pending usage", got)
+	}
+	if db.keys[0].Requests != 0 {
+		t.Error("usage should not be written before the flush")
+	}
+
+	db.fail = "UPDATE api_keys SET requests"
+	if err := store.FlushUsage(); err == nil {
+		t.Error("expected the flush to fail")
+	}
+	db.fail = ""
+	store.Authenticate(first.Token)
+	if err := store.FlushUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if db.keys[0].Requests != 4 || db.keys[1].Requests != 1 {
+		t.Errorf("flushed requests = %d and %d, expected the failed flush to be retried", db.keys[0].Requests, db.keys[1].Requests)
+	}
+	if got := requests(); !reflect.DeepEqual(got, []int64{4, 1}) {
+		t.Errorf("requests after the flush = %v", got)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	store := NewStore(&fakeDB{}, time.Minute)
+	reader, _ := store.Issue("reader", []string{ScopeStatsRead})
+	admin, _ := store.Issue("admin", []string{ScopeAdmin})
+	revoked, _ := store.Issue("revoked", []string{ScopeStatsRead})
+	store.Revoke(revoked.ID)
+
+	handler := store.Middleware(func(r *http.Request) string {
+		switch r.URL.Path {
+		case "/health":
+			return ""
+		case "/record":
+			return ScopeRecordsWrite
+		}
+		return ScopeStatsRead
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		target string
+		header string
+		value  string
+		want   int
+	}{
+		{"/health", "", "", http.StatusOK},
+		{"/leaders", "", "", http.StatusUnauthorized},
+		{"/leaders", "Authorization", "Bearer " + reader.Token, http.StatusOK},
+		{"/leaders", "X-API-Key", reader.Token, http.StatusOK},
+		{"/leaders", "Authorization", "Basic " + reader.Token, http.StatusUnauthorized},
+		{"/leaders", "X-API-Key", "nba_unknown", http.StatusUnauthorized},
+		{"/leaders", "X-API-Key", revoked.Token, http.StatusUnauthorized},
+		{"/record", "X-API-Key", reader.Token, http.StatusForbidden},
+		{"/record", "Authorization", "Bearer " + admin.Token, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s with %s %.12s: got %d, expected %d", test.target, test.header, test.value, w.Code, test.want)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: missing WWW-Authenticate header", test.target)
+		}
+	}
+}
+
+func TestAdminHandlers(t *testing.T) {
+	db := &fakeDB{}
+	store := NewStore(db, time.Minute)
+
+	serve := func(handler http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	w := serve(store.IssueKey, "POST", "/admin/keys", `{"name": "importer", "scopes": ["records:write"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("IssueKey: got %d %s", w.Code, w.Body.String())
+	}
+	var issued IssuedKey
+	if err := json.Unmarshal(w.Body.Bytes(), &issued); err != nil || issued.Token == "" || issued.Name != "importer" {
+		t.Errorf("IssueKey: unexpected response %s", w.Body.String())
+	}
+
+	for _, body := range []string{`{"name": "importer", "scopes": ["records:delete"]}`, `{"scopes": ["admin"]}`, `not json`} {
+		if w := serve(store.IssueKey, "POST", "/admin/keys", body); w.Code != http.StatusBadRequest {
+			t.Errorf("IssueKey(%s): got %d, expected 400", body, w.Code)
+		}
+	}
+
+	w = serve(store.ListKeys, "GET", "/admin/keys", "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), issued.Token) || !strings.Contains(w.Body.String(), `"name":"importer"`) {
+		t.Errorf("ListKeys: got %d %s", w.Code, w.Body.String())
+	}
+
+	for _, test := range []struct {
+		target string
+		want   int
+	}{
+		{"/admin/keys?id=x", http.StatusBadRequest},
+		{"/admin/keys?id=1", http.StatusNoContent},
+		{"/admin/keys?id=1", http.StatusNotFound},
+		{"/admin/keys?id=7", http.StatusNotFound},
+	} {
+		if w := serve(store.RevokeKey, "DELETE", test.target, ""); w.Code != test.want {
+			t.Errorf("RevokeKey(%s): got %d, expected %d", test.target, w.Code, test.want)
+		}
+	}
+
+	db.fail = "api_keys"
+	if w := serve(store.ListKeys, "GET", "/admin/keys", ""); w.Code != http.StatusInternalServerError {
+		t.Errorf("ListKeys with a failing database: got %d, expected 500", w.Code)
+	}
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/common"
+)
+
+// fakeDB keeps the api_keys table in memory, interpreting the statements of the Store.
+// A statement containing fail, if set, returns an error.
+type fakeDB struct {
+	mu   sync.Mutex
+	keys []*fakeKey
+	fail string
+}
+
+type fakeKey struct {
+	Key
+	hash string
+}
+
+func (db *fakeDB) check(query string) error {
+	if db.fail != "" && strings.Contains(query, db.fail) {
+		return fmt.Errorf("fake: %s failed", db.fail)
+	}
+	return nil
+}
+
+func (db *fakeDB) Exec(query string, args ...interface{}) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.check(query); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(query, "UPDATE api_keys SET requests") {
+		return fmt.Errorf("fake: unexpected statement %s", query)
+	}
+	for _, key := range db.keys {
+		if key.ID == args[0].(int) {
+			key.Requests += args[1].(int64)
+			lastUsedAt := args[2].(time.Time)
+			if key.LastUsedAt == nil || lastUsedAt.After(*key.LastUsedAt) {
+				key.LastUsedAt = &lastUsedAt
+			}
+		}
+	}
+	return nil
+}
+
+func (db *fakeDB) Query(query string, args ...interface{}) (common.Rows, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.check(query); err != nil {
+		return nil, err
+	}
+
+	var rows [][]interface{}
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO api_keys"):
+		for _, key := range db.keys {
+			if key.hash == args[1].(string) {
+				return nil, fmt.Errorf("fake: duplicate key_hash")
+			}
+		}
+		key := &fakeKey{Key: Key{ID: len(db.keys) + 1, Name: args[0].(string), Scopes: args[2].([]string), CreatedAt: time.Now()}, hash: args[1].(string)}
+		db.keys = append(db.keys, key)
+		rows = append(rows, []interface{}{key.ID, key.CreatedAt})
+	case strings.HasPrefix(query, "UPDATE api_keys SET revoked_at"):
+		for _, key := range db.keys {
+			if key.ID == args[0].(int) && key.RevokedAt == nil {
+				now := time.Now()
+				key.RevokedAt = &now
+				rows = append(rows, []interface{}{key.ID})
+			}
+		}
+	case strings.HasPrefix(query, "SELECT id, name, scopes, created_at FROM api_keys WHERE key_hash"):
+		for _, key := range db.keys {
+			if key.hash == args[0].(string) && key.RevokedAt == nil {
+				rows = append(rows, []interface{}{key.ID, key.Name, key.Scopes, key.CreatedAt})
+			}
+		}
+	case strings.HasPrefix(query, "SELECT id, name, scopes, created_at, revoked_at"):
+		for _, key := range db.keys {
+			rows = append(rows, []interface{}{key.ID, key.Name, key.Scopes, key.CreatedAt, key.RevokedAt, key.LastUsedAt, key.Requests})
+		}
+	default:
+		return nil, fmt.Errorf("fake: unexpected statement %s", query)
+	}
+	return &fakeRows{rows: rows, index: -1}, nil
+}
+
+type fakeRows struct {
+	rows  [][]interface{}
+	index int
+}
+
+func (r *fakeRows) Close() {}
+
+func (r *fakeRows) Err() error {
+	return nil
+}
+
+func (r *fakeRows) Next() bool {
+	r.index++
+	return r.index < len(r.rows)
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	row := r.rows[r.index]
+	if len(dest) != len(row) {
+		return fmt.Errorf("fake: scanning %d columns into %d destinations", len(row), len(dest))
+	}
+	for i, value := range row {
+		target := reflect.ValueOf(dest[i]).Elem()
+		v := reflect.ValueOf(value)
+		if !v.Type().ConvertibleTo(target.Type()) {
+			return fmt.Errorf("fake: cannot scan %T into %s", value, target.Type())
+		}
+		target.Set(v.Convert(target.Type()))
+	}
+	return nil
+}
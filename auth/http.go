@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// token returns the API key of the request, sent as a bearer token or in the X-API-Key header
+func token(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// Middleware authenticates the requests with an API key granting the scope returned by requiredScope.
+// Requests for which requiredScope returns an empty scope are public.
+func (s *Store) Middleware(requiredScope func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope := requiredScope(r)
+			if scope == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token := token(r)
+			if token == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Missing API key", http.StatusUnauthorized)
+				return
+			}
+			key, err := s.Authenticate(token)
+			if errors.Is(err, ErrInvalidKey) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !key.HasScope(scope) {
+				http.Error(w, "API key lacks the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type issueRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// IssueKey creates a key and responds with its token, shown only this once
+func (s *Store) IssueKey(w http.ResponseWriter, r *http.Request) {
+	var request issueRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	defer r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := validate(request.Name, request.Scopes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	issued, err := s.Issue(request.Name, request.Scopes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := json.Marshal(issued)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(response)
+}
+
+// ListKeys responds with all the keys and their usage, without their tokens
+func (s *Store) ListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := json.Marshal(keys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// RevokeKey revokes the key with the id query parameter
+func (s *Store) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	err = s.Revoke(id)
+	if errors.Is(err, ErrKeyNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
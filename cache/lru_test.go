@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	l := newLRU(2, time.Minute)
+	l.set("a", "1")
+	l.set("b", "2")
+	l.get("a")
+	l.set("c", "3")
+
+	if _, ok := l.get("b"); ok {
+		t.Error("least recently used key b was not evicted")
+	}
+	for key, want := range map[string]string{"a": "1", "c": "3"} {
+		if value, ok := l.get(key); !ok || value != want {
+			t.Errorf("get(%q) = %q, %v, want %q", key, value, ok, want)
+		}
+	}
+}
+
+func TestLRUExpiresAndRemoves(t *testing.T) {
+	l := newLRU(10, 10*time.Millisecond)
+	l.set("a", "1")
+	l.set("b", "2")
+	l.remove("b")
+	if _, ok := l.get("b"); ok {
+		t.Error("removed key b is still cached")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := l.get("a"); ok {
+		t.Error("expired key a is still cached")
+	}
+	if len(l.entries) != 0 || l.order.Len() != 0 {
+		t.Errorf("got %d entries after expiry, want none", len(l.entries))
+	}
+}
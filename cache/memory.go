@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/common"
+)
+
+// ErrNotFound is returned by MemoryCache for a missing or expired key
+var ErrNotFound = errors.New("cache: key not found")
+
+type memorySortedSet struct {
+	scores    map[string]float64
+	expiresAt time.Time
+}
+
+// MemoryCache keeps all values in process, for local development and tests without Redis.
+// Values are not shared between pods. At most capacity values are kept, evicting the least recently used ones,
+// so values cached without expiry, such as past snapshots, do not grow the process without bound.
+type MemoryCache struct {
+	// mu makes the operations reading and writing a key atomic
+	mu         sync.Mutex
+	values     *lru
+	sortedSets map[string]*memorySortedSet
+}
+
+func NewMemoryCache(capacity int) *MemoryCache {
+	return &MemoryCache{
+		values:     newLRU(capacity, 0),
+		sortedSets: make(map[string]*memorySortedSet),
+	}
+}
+
+// lookupSortedSet returns the sorted set of the key, dropping it if expired. The caller holds the lock.
+func (m *MemoryCache) lookupSortedSet(key string) (*memorySortedSet, bool) {
+	set, ok := m.sortedSets[key]
+	if ok && !set.expiresAt.IsZero() && time.Now().After(set.expiresAt) {
+		delete(m.sortedSets, key)
+		return nil, false
+	}
+	return set, ok
+}
+
+// stringValue returns the value as Redis stores it
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(value)
+}
+
+func (m *MemoryCache) Get(key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.values.get(key)
+	countLookup("memory", ok)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (m *MemoryCache) Set(key string, value interface{}, expiration time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values.setFor(key, stringValue(value), expiration)
+	return nil
+}
+
+func (m *MemoryCache) Del(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values.remove(key)
+	delete(m.sortedSets, key)
+	return nil
+}
+
+func (m *MemoryCache) SetNX(key string, value interface{}, expiration time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.values.get(key); ok {
+		return false, nil
+	}
+	m.values.setFor(key, stringValue(value), expiration)
+	return true, nil
+}
+
+func (m *MemoryCache) CompareAndDelete(key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current, ok := m.values.get(key); ok && current == value {
+		m.values.remove(key)
+	}
+	return nil
+}
+
+func (m *MemoryCache) Rename(key, newKey string, expiration time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if set, ok := m.lookupSortedSet(key); ok {
+		delete(m.sortedSets, key)
+		m.values.remove(newKey)
+		set.expiresAt = time.Time{}
+		if expiration > 0 {
+			set.expiresAt = time.Now().Add(expiration)
+		}
+		m.sortedSets[newKey] = set
+		return nil
+	}
+	value, ok := m.values.get(key)
+	if !ok {
+		return nil
+	}
+	m.values.remove(key)
+	delete(m.sortedSets, newKey)
+	m.values.setFor(newKey, value, expiration)
+	return nil
+}
+
+// Expire sets the value or the sorted set of the key to expire after the given duration, doing nothing if the key does not exist
+func (m *MemoryCache) Expire(key string, expiration time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if value, ok := m.values.get(key); ok {
+		m.values.setFor(key, value, expiration)
+	}
+	if set, ok := m.lookupSortedSet(key); ok {
+		set.expiresAt = time.Now().Add(expiration)
+	}
+	return nil
+}
+
+func (m *MemoryCache) ZAdd(key string, score float64, member string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	set, ok := m.lookupSortedSet(key)
+	if !ok {
+		set = &memorySortedSet{scores: make(map[string]float64)}
+		m.sortedSets[key] = set
+	}
+	set.scores[member] = score
+	return nil
+}
+
+func (m *MemoryCache) ZCard(key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	set, ok := m.lookupSortedSet(key)
+	if !ok {
+		return 0, nil
+	}
+	return int64(len(set.scores)), nil
+}
+
+// ZRevRangeWithScores returns the members by descending score, as Redis does, ties by descending member
+func (m *MemoryCache) ZRevRangeWithScores(key string) ([]common.ScoredMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	set, ok := m.lookupSortedSet(key)
+	if !ok {
+		return []common.ScoredMember{}, nil
+	}
+	members := make([]common.ScoredMember, 0, len(set.scores))
+	for member, score := range set.scores {
+		members = append(members, common.ScoredMember{Member: member, Score: score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score > members[j].Score
+		}
+		return members[i].Member > members[j].Member
+	})
+	return members, nil
+}
+
+func (m *MemoryCache) Close() {}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/common"
+)
+
+func TestMemoryCacheExpiry(t *testing.T) {
+	m := NewMemoryCache(1000)
+	m.Set("permanent", []byte("1"), 0)
+	m.Set("expiring", "2", 10*time.Millisecond)
+
+	if value, err := m.Get("expiring"); err != nil || value != "2" {
+		t.Errorf("Get(expiring) = %q, %v before expiry", value, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := m.Get("expiring"); err != ErrNotFound {
+		t.Errorf("Get(expiring) error = %v after expiry, want ErrNotFound", err)
+	}
+	if value, err := m.Get("permanent"); err != nil || value != "1" {
+		t.Errorf("Get(permanent) = %q, %v", value, err)
+	}
+}
+
+func TestMemoryCacheIsBounded(t *testing.T) {
+	m := NewMemoryCache(2)
+	m.Set("a", "1", 0)
+	m.Set("b", "2", 0)
+	m.Get("a")
+	m.Set("c", "3", 0)
+	if _, err := m.Get("b"); err != ErrNotFound {
+		t.Error("least recently used value was not evicted")
+	}
+	if value, err := m.Get("a"); err != nil || value != "1" {
+		t.Errorf("Get(a) = %q, %v", value, err)
+	}
+}
+
+func TestMemoryCacheLocksAndRename(t *testing.T) {
+	m := NewMemoryCache(1000)
+	if ok, _ := m.SetNX("lock", 1, time.Minute); !ok {
+		t.Error("SetNX did not set a missing key")
+	}
+	if ok, _ := m.SetNX("lock", "owner", time.Minute); ok {
+		t.Error("SetNX set an existing key")
+	}
+	m.CompareAndDelete("lock", "other")
+	if _, err := m.Get("lock"); err != nil {
+		t.Error("CompareAndDelete deleted the key holding another value")
+	}
+	m.CompareAndDelete("lock", "1")
+	if _, err := m.Get("lock"); err != ErrNotFound {
+		t.Error("CompareAndDelete did not delete the key holding the value")
+	}
+
+	m.Set("key", "value", 0)
+	if err := m.Rename("key", "key_stale", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("key"); err != ErrNotFound {
+		t.Error("renamed key still exists")
+	}
+	if value, _ := m.Get("key_stale"); value != "value" {
+		t.Errorf("got renamed value %q, want value", value)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := m.Get("key_stale"); err != ErrNotFound {
+		t.Error("renamed key did not expire")
+	}
+	if err := m.Rename("missing", "missing_stale", 0); err != nil {
+		t.Errorf("Rename of a missing key failed: %v", err)
+	}
+}
+
+func TestMemoryCacheSortedSets(t *testing.T) {
+	m := NewMemoryCache(1000)
+	m.ZAdd("leaders", 20.5, "1")
+	m.ZAdd("leaders", 31.2, "2")
+	m.ZAdd("leaders", 20.5, "3")
+	m.ZAdd("leaders", 25, "1")
+
+	if n, _ := m.ZCard("leaders"); n != 3 {
+		t.Errorf("ZCard = %d, want 3", n)
+	}
+	members, _ := m.ZRevRangeWithScores("leaders")
+	want := []common.ScoredMember{{Member: "2", Score: 31.2}, {Member: "1", Score: 25}, {Member: "3", Score: 20.5}}
+	if !reflect.DeepEqual(members, want) {
+		t.Errorf("ZRevRangeWithScores = %v, want %v", members, want)
+	}
+
+	m.ZAdd("leaders_building", 1, "4")
+	m.Rename("leaders_building", "leaders", 0)
+	if members, _ := m.ZRevRangeWithScores("leaders"); len(members) != 1 || members[0].Member != "4" {
+		t.Errorf("ZRevRangeWithScores = %v after Rename, want the renamed set", members)
+	}
+
+	m.Expire("leaders", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if n, _ := m.ZCard("leaders"); n != 0 {
+		t.Errorf("ZCard = %d after expiry, want 0", n)
+	}
+}
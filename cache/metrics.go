@@ -0,0 +1,35 @@
+package cache
+
+import "expvar"
+
+// metrics counts the hits and misses of each cache tier, published at /debug/vars
+var metrics = expvar.NewMap("cache")
+
+func init() {
+	metrics.Set("local_hit_ratio", expvar.Func(func() interface{} { return hitRatio("local") }))
+	metrics.Set("redis_hit_ratio", expvar.Func(func() interface{} { return hitRatio("redis") }))
+	metrics.Set("memory_hit_ratio", expvar.Func(func() interface{} { return hitRatio("memory") }))
+}
+
+func countLookup(tier string, hit bool) {
+	if hit {
+		metrics.Add(tier+"_hits", 1)
+	} else {
+		metrics.Add(tier+"_misses", 1)
+	}
+}
+
+func counter(name string) int64 {
+	if v, ok := metrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func hitRatio(tier string) float64 {
+	hits, misses := counter(tier+"_hits"), counter(tier+"_misses")
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
@@ -2,8 +2,13 @@ package cache
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
 
 	"github.com/go-redis/redis/v8"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/common"
 )
 
 type RedisCache struct {
@@ -11,11 +16,30 @@ type RedisCache struct {
 	ctx    context.Context
 }
 
-func NewRedisCache(ctx context.Context, addr, password string, db int) (*RedisCache, error) {
+type RedisOptions struct {
+	Addr string
+	DB   int
+	// Credentials returns the ACL user, empty for the default user, and the password, empty to skip AUTH.
+	// It is called for every new connection, so rotated credentials are used without a restart.
+	Credentials func() (username, password string, err error)
+	// TLSConfig enables TLS if set
+	TLSConfig *tls.Config
+}
+
+func NewRedisCache(ctx context.Context, options RedisOptions) (*RedisCache, error) {
+	// The database is selected after AUTH, as go-redis would select it before calling OnConnect
 	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
+		Addr:      options.Addr,
+		TLSConfig: options.TLSConfig,
+		OnConnect: func(ctx context.Context, conn *redis.Conn) error {
+			if err := authenticate(ctx, conn, options.Credentials); err != nil {
+				return err
+			}
+			if options.DB > 0 {
+				return conn.Select(ctx, options.DB).Err()
+			}
+			return nil
+		},
 	})
 
 	_, err := client.Ping(ctx).Result()
@@ -29,18 +53,95 @@ func NewRedisCache(ctx context.Context, addr, password string, db int) (*RedisCa
 	}, nil
 }
 
+// authenticate sends AUTH with the current credentials, as the default user unless a username is set
+func authenticate(ctx context.Context, conn *redis.Conn, credentials func() (string, string, error)) error {
+	if credentials == nil {
+		return nil
+	}
+	username, password, err := credentials()
+	if err != nil {
+		return err
+	}
+	switch {
+	case password == "":
+		return nil
+	case username == "":
+		return conn.Auth(ctx, password).Err()
+	default:
+		return conn.AuthACL(ctx, username, password).Err()
+	}
+}
+
 func (r *RedisCache) Get(key string) (string, error) {
-	return r.client.Get(r.ctx, key).Result()
+	value, err := r.client.Get(r.ctx, key).Result()
+	countLookup("redis", err == nil)
+	return value, err
 }
 
-func (r *RedisCache) Set(key string, value interface{}) error {
-	return r.client.Set(r.ctx, key, value, 0).Err()
+func (r *RedisCache) Set(key string, value interface{}, expiration time.Duration) error {
+	return r.client.Set(r.ctx, key, value, expiration).Err()
 }
 
 func (r *RedisCache) Del(key string) error {
 	return r.client.Del(r.ctx, key).Err()
 }
 
+func (r *RedisCache) SetNX(key string, value interface{}, expiration time.Duration) (bool, error) {
+	return r.client.SetNX(r.ctx, key, value, expiration).Result()
+}
+
+// compareAndDeleteScript deletes KEYS[1] if it holds ARGV[1]
+var compareAndDeleteScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (r *RedisCache) CompareAndDelete(key, value string) error {
+	return compareAndDeleteScript.Run(r.ctx, r.client, []string{key}, value).Err()
+}
+
+// renameScript renames KEYS[1] to KEYS[2] if it exists, expiring it after ARGV[1] milliseconds or never if 0
+var renameScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("RENAME", KEYS[1], KEYS[2])
+if tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[2], ARGV[1])
+else
+	redis.call("PERSIST", KEYS[2])
+end
+return 1`)
+
+func (r *RedisCache) Rename(key, newKey string, expiration time.Duration) error {
+	return renameScript.Run(r.ctx, r.client, []string{key, newKey}, expiration.Milliseconds()).Err()
+}
+
+func (r *RedisCache) Expire(key string, expiration time.Duration) error {
+	return r.client.Expire(r.ctx, key, expiration).Err()
+}
+
+func (r *RedisCache) ZAdd(key string, score float64, member string) error {
+	return r.client.ZAdd(r.ctx, key, &redis.Z{Score: score, Member: member}).Err()
+}
+
+func (r *RedisCache) ZCard(key string) (int64, error) {
+	return r.client.ZCard(r.ctx, key).Result()
+}
+
+func (r *RedisCache) ZRevRangeWithScores(key string) ([]common.ScoredMember, error) {
+	zs, err := r.client.ZRevRangeWithScores(r.ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]common.ScoredMember, 0, len(zs))
+	for _, z := range zs {
+		members = append(members, common.ScoredMember{Member: fmt.Sprint(z.Member), Score: z.Score})
+	}
+	return members, nil
+}
+
 func (r *RedisCache) Close() {
 	r.client.Close()
 }
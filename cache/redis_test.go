@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis accepts connections speaking RESP, recording the commands and rejecting every command
+// other than AUTH before a successful AUTH if a password is set
+type fakeRedis struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	username string
+	password string
+	commands [][]string
+}
+
+func newFakeRedis(t *testing.T, username, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{listener: listener, username: username, password: password}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	f.mu.Lock()
+	username, password := f.username, f.password
+	f.mu.Unlock()
+	if username == "" {
+		username = "default"
+	}
+	authenticated := password == ""
+	for {
+		var count int
+		if _, err := fmt.Fscanf(reader, "*%d\r\n", &count); err != nil {
+			return
+		}
+		command := make([]string, count)
+		for i := range command {
+			var length int
+			if _, err := fmt.Fscanf(reader, "$%d\r\n", &length); err != nil {
+				return
+			}
+			arg := make([]byte, length+2)
+			if _, err := io.ReadFull(reader, arg); err != nil {
+				return
+			}
+			command[i] = string(arg[:length])
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, command)
+		f.mu.Unlock()
+
+		reply := "+OK\r\n"
+		switch name := strings.ToUpper(command[0]); {
+		case name == "AUTH":
+			gotUsername := "default"
+			if len(command) == 3 {
+				gotUsername = command[1]
+			}
+			authenticated = gotUsername == username && command[len(command)-1] == password
+			if !authenticated {
+				reply = "-WRONGPASS invalid username-password pair\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case name == "PING":
+			reply = "+PONG\r\n"
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) recorded() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]string(nil), f.commands...)
+}
+
+func TestRedisCacheAuthentication(t *testing.T) {
+	tests := []struct {
+		name               string
+		username, password string
+		db                 int
+		credentials        func() (string, string, error)
+		wantErr            bool
+		want               [][]string
+	}{
+		{"no password", "", "", 0, nil, false, [][]string{{"ping"}}},
+		{"default user", "", "secret", 0, func() (string, string, error) { return "", "secret", nil }, false,
+			[][]string{{"auth", "secret"}, {"ping"}}},
+		{"acl user with database", "nba", "secret", 2, func() (string, string, error) { return "nba", "secret", nil }, false,
+			[][]string{{"auth", "nba", "secret"}, {"select", "2"}, {"ping"}}},
+		{"wrong password", "", "secret", 0, func() (string, string, error) { return "", "old", nil }, true, nil},
+		{"missing password", "", "secret", 2, nil, true, nil},
+		{"credentials error", "", "secret", 0, func() (string, string, error) { return "", "", fmt.Errorf("no secret") }, true, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeRedis(t, test.username, test.password)
+			redisCache, err := NewRedisCache(context.Background(), RedisOptions{
+				Addr:        server.listener.Addr().String(),
+				DB:          test.db,
+				Credentials: test.credentials,
+			})
+			if test.wantErr {
+				if err == nil {
+					redisCache.Close()
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer redisCache.Close()
+			if got := server.recorded(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("commands = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRedisCacheRotatedPassword(t *testing.T) {
+	server := newFakeRedis(t, "", "first")
+	var mu sync.Mutex
+	password := "first"
+	redisCache, err := NewRedisCache(context.Background(), RedisOptions{
+		Addr: server.listener.Addr().String(),
+		Credentials: func() (string, string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return "", password, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redisCache.Close()
+
+	// The server and the secret rotate, so new connections authenticate with the new password
+	server.mu.Lock()
+	server.password = "second"
+	server.mu.Unlock()
+	mu.Lock()
+	password = "second"
+	mu.Unlock()
+	conn := redisCache.client.Conn(context.Background())
+	defer conn.Close()
+	if err := conn.Ping(context.Background()).Err(); err != nil {
+		t.Fatalf("a new connection failed after the rotation: %v", err)
+	}
+}
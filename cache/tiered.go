@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// invalidationChannel carries the keys deleted or renamed by any pod, so all pods drop their local copies
+const invalidationChannel = "cache_invalidations"
+
+// TieredCache keeps the most recently used values in process for a short TTL in front of Redis.
+// Sorted sets and locks are not cached locally, so locks are set and released in Redis only, without invalidations.
+type TieredCache struct {
+	*RedisCache
+	local  *lru
+	pubsub *redis.PubSub
+}
+
+func NewTieredCache(remote *RedisCache, capacity int, ttl time.Duration) (*TieredCache, error) {
+	pubsub := remote.client.Subscribe(remote.ctx, invalidationChannel)
+	// Wait for the subscription, so no invalidation published afterwards is missed
+	if _, err := pubsub.Receive(remote.ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	t := &TieredCache{
+		RedisCache: remote,
+		local:      newLRU(capacity, ttl),
+		pubsub:     pubsub,
+	}
+	go func() {
+		for message := range pubsub.Channel() {
+			t.local.remove(message.Payload)
+		}
+	}()
+	return t, nil
+}
+
+func (t *TieredCache) Get(key string) (string, error) {
+	if value, ok := t.local.get(key); ok {
+		countLookup("local", true)
+		return value, nil
+	}
+	countLookup("local", false)
+
+	value, err := t.RedisCache.Get(key)
+	if err != nil {
+		return "", err
+	}
+	t.local.set(key, value)
+	return value, nil
+}
+
+func (t *TieredCache) Set(key string, value interface{}, expiration time.Duration) error {
+	if err := t.RedisCache.Set(key, value, expiration); err != nil {
+		return err
+	}
+	t.local.set(key, stringValue(value))
+	return nil
+}
+
+func (t *TieredCache) Del(key string) error {
+	if err := t.RedisCache.Del(key); err != nil {
+		return err
+	}
+	return t.invalidate(key)
+}
+
+func (t *TieredCache) Rename(key, newKey string, expiration time.Duration) error {
+	if err := t.RedisCache.Rename(key, newKey, expiration); err != nil {
+		return err
+	}
+	if err := t.invalidate(key); err != nil {
+		return err
+	}
+	return t.invalidate(newKey)
+}
+
+// invalidate drops the local copies of the key in this and all other pods
+func (t *TieredCache) invalidate(key string) error {
+	t.local.remove(key)
+	return t.client.Publish(t.ctx, invalidationChannel, key).Err()
+}
+
+func (t *TieredCache) Close() {
+	t.pubsub.Close()
+	t.RedisCache.Close()
+}
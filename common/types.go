@@ -10,3 +10,13 @@ type Rows interface {
 	Next() bool
 	Scan(dest ...interface{}) error
 }
+
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+type Tx interface {
+	Exec(query string, args ...interface{}) error
+	Query(query string, args ...interface{}) (Rows, error)
+}
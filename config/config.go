@@ -0,0 +1,536 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/nba"
+	"github.com/ShimonMoldawskiy/NBAStatistics/server"
+)
+
+// Secret is a configuration value that is never printed or marshaled
+type Secret string
+
+const redacted = "REDACTED"
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+type Config struct {
+	// Listen is the address the HTTP server listens on
+	Listen string `yaml:"listen"`
+	// DebugListen is the address of an internal plain HTTP listener serving /debug/vars, empty to disable it
+	DebugListen string         `yaml:"debugListen"`
+	TLS         TLSConfig      `yaml:"tls"`
+	Auth        AuthConfig     `yaml:"auth"`
+	Storage     StorageConfig  `yaml:"storage"`
+	Postgres    PostgresConfig `yaml:"postgres"`
+	Redis       RedisConfig    `yaml:"redis"`
+	Cache       CacheConfig    `yaml:"cache"`
+	Views       ViewsConfig    `yaml:"views"`
+}
+
+// TLSConfig enables HTTPS on the listen address when a certificate is set
+type TLSConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// ReloadInterval is how often the certificate files are checked for changes
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+	// ClientAuth is none, optional or require; client certificates are verified against the CAs in ClientCAFile
+	ClientAuth   string `yaml:"clientAuth"`
+	ClientCAFile string `yaml:"clientCAFile"`
+	// RedirectListen is the address of a plain HTTP listener redirecting to HTTPS, empty to disable it
+	RedirectListen string `yaml:"redirectListen"`
+}
+
+// Enabled reports whether the server is served over HTTPS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// AuthConfig requires an API key on every request when enabled
+type AuthConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// UsageFlushInterval is how often the usage of the keys counted in process is added to the database
+	UsageFlushInterval time.Duration `yaml:"usageFlushInterval"`
+	// CacheTTL is how long an authenticated key is kept in process, and a key revoked on another pod still accepted
+	CacheTTL time.Duration `yaml:"cacheTTL"`
+}
+
+// StorageConfig selects the database of the statistics
+type StorageConfig struct {
+	// Backend is postgres, or sqlite to store them in a local file, e.g. on an edge node without PostgreSQL
+	Backend string `yaml:"backend"`
+	// SQLitePath is the database file of the sqlite backend, created if it does not exist
+	SQLitePath string `yaml:"sqlitePath"`
+}
+
+type PostgresConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password Secret `yaml:"password"`
+	// PasswordFile is read into Password, e.g. from a mounted Kubernetes secret
+	PasswordFile string `yaml:"passwordFile"`
+	Database     string `yaml:"database"`
+	SSLMode      string `yaml:"sslMode"`
+	// SSLRootCert is the CA verifying the server certificate, e.g. with sslMode verify-full;
+	// SSLCert and SSLKey are the client certificate, if the server requires one
+	SSLRootCert string `yaml:"sslRootCert"`
+	SSLCert     string `yaml:"sslCert"`
+	SSLKey      string `yaml:"sslKey"`
+}
+
+type RedisConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Password Secret `yaml:"password"`
+	// PasswordFile is read into Password, e.g. from a mounted Kubernetes secret
+	PasswordFile string `yaml:"passwordFile"`
+	// Username is the ACL user, empty for the default user
+	Username string `yaml:"username"`
+	DB       int    `yaml:"db"`
+	// TLS enables TLS, verifying the server certificate against the CAs in CAFile or the system CAs.
+	// CertFile and KeyFile are the client certificate, if the server requires one.
+	TLS      bool   `yaml:"tls"`
+	CAFile   string `yaml:"caFile"`
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+type CacheConfig struct {
+	// Backend is redis, memory or none
+	Backend              string                   `yaml:"backend"`
+	StaleWhileRevalidate bool                     `yaml:"staleWhileRevalidate"`
+	TTLs                 map[string]time.Duration `yaml:"ttls"`
+	// Disabled are the endpoints whose values are not cached, see nba.CacheEndpoints
+	Disabled []string `yaml:"disabled"`
+	// LocalSize is the number of values kept in process in front of Redis, 0 to disable the local tier
+	LocalSize int           `yaml:"localSize"`
+	LocalTTL  time.Duration `yaml:"localTTL"`
+	// MemorySize is the number of values kept by the memory and none backends
+	MemorySize int `yaml:"memorySize"`
+}
+
+type ViewsConfig struct {
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+}
+
+func Default() *Config {
+	ttls := make(map[string]time.Duration)
+	for kind, ttl := range nba.DefaultCacheTTLs {
+		ttls[kind] = ttl
+	}
+	return &Config{
+		Listen:   ":8080",
+		TLS:      TLSConfig{ReloadInterval: time.Minute, ClientAuth: server.ClientAuthNone},
+		Auth:     AuthConfig{UsageFlushInterval: time.Minute, CacheTTL: 30 * time.Second},
+		Storage:  StorageConfig{Backend: "postgres", SQLitePath: "nba.db"},
+		Postgres: PostgresConfig{Port: 5432, SSLMode: "disable"},
+		Redis:    RedisConfig{Port: 6379},
+		Cache:    CacheConfig{Backend: "redis", TTLs: ttls, LocalTTL: 5 * time.Second, MemorySize: 10000},
+		Views:    ViewsConfig{RefreshInterval: 5 * time.Minute},
+	}
+}
+
+// setting is a configuration value that can be set from the environment and the command line
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+func parseInt(value string, dest *int) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*dest = n
+	return nil
+}
+
+func parseDuration(value string, dest *time.Duration) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*dest = d
+	return nil
+}
+
+var settings = []setting{
+	{"LISTEN_ADDR", "listen", "address the HTTP server listens on", func(c *Config, v string) error { c.Listen = v; return nil }},
+	{"DEBUG_LISTEN_ADDR", "debug-listen", "address of an internal HTTP listener serving /debug/vars", func(c *Config, v string) error { c.DebugListen = v; return nil }},
+	{"TLS_CERT_FILE", "tls-cert-file", "TLS certificate file, enables HTTPS", func(c *Config, v string) error { c.TLS.CertFile = v; return nil }},
+	{"TLS_KEY_FILE", "tls-key-file", "TLS private key file", func(c *Config, v string) error { c.TLS.KeyFile = v; return nil }},
+	{"TLS_RELOAD_INTERVAL", "tls-reload-interval", "interval between checks of the TLS certificate files for changes", func(c *Config, v string) error {
+		return parseDuration(v, &c.TLS.ReloadInterval)
+	}},
+	{"TLS_CLIENT_AUTH", "tls-client-auth", "client certificate authentication: none, optional or require", func(c *Config, v string) error { c.TLS.ClientAuth = v; return nil }},
+	{"TLS_CLIENT_CA_FILE", "tls-client-ca-file", "CA certificates verifying the client certificates", func(c *Config, v string) error { c.TLS.ClientCAFile = v; return nil }},
+	{"TLS_REDIRECT_LISTEN", "tls-redirect-listen", "address of an HTTP listener redirecting to HTTPS", func(c *Config, v string) error { c.TLS.RedirectListen = v; return nil }},
+	{"AUTH_ENABLED", "auth-enabled", "require an API key on every request", func(c *Config, v string) error {
+		enabled, err := strconv.ParseBool(v)
+		c.Auth.Enabled = enabled
+		return err
+	}},
+	{"AUTH_USAGE_FLUSH_INTERVAL", "auth-usage-flush-interval", "interval between writes of the API key usage to the database", func(c *Config, v string) error {
+		return parseDuration(v, &c.Auth.UsageFlushInterval)
+	}},
+	{"AUTH_CACHE_TTL", "auth-cache-ttl", "how long authenticated API keys are cached in process, 0 to disable", func(c *Config, v string) error {
+		return parseDuration(v, &c.Auth.CacheTTL)
+	}},
+	{"STORAGE_BACKEND", "storage-backend", "storage backend: postgres or sqlite", func(c *Config, v string) error { c.Storage.Backend = v; return nil }},
+	{"STORAGE_SQLITE_PATH", "storage-sqlite-path", "database file of the sqlite storage backend", func(c *Config, v string) error { c.Storage.SQLitePath = v; return nil }},
+	{"POSTGRES_HOST", "postgres-host", "PostgreSQL host", func(c *Config, v string) error { c.Postgres.Host = v; return nil }},
+	{"POSTGRES_PORT", "postgres-port", "PostgreSQL port", func(c *Config, v string) error { return parseInt(v, &c.Postgres.Port) }},
+	{"POSTGRES_USER", "postgres-user", "PostgreSQL user", func(c *Config, v string) error { c.Postgres.User = v; return nil }},
+	{"POSTGRES_PASSWORD", "", "", func(c *Config, v string) error { c.Postgres.Password = Secret(v); return nil }},
+	{"POSTGRES_PASSWORD_FILE", "postgres-password-file", "file holding the PostgreSQL password", func(c *Config, v string) error { c.Postgres.PasswordFile = v; return nil }},
+	{"POSTGRES_DB", "postgres-db", "PostgreSQL database", func(c *Config, v string) error { c.Postgres.Database = v; return nil }},
+	{"POSTGRES_SSLMODE", "postgres-sslmode", "PostgreSQL SSL mode", func(c *Config, v string) error { c.Postgres.SSLMode = v; return nil }},
+	{"POSTGRES_SSLROOTCERT", "postgres-sslrootcert", "CA certificates verifying the PostgreSQL server", func(c *Config, v string) error { c.Postgres.SSLRootCert = v; return nil }},
+	{"POSTGRES_SSLCERT", "postgres-sslcert", "PostgreSQL client certificate", func(c *Config, v string) error { c.Postgres.SSLCert = v; return nil }},
+	{"POSTGRES_SSLKEY", "postgres-sslkey", "PostgreSQL client private key", func(c *Config, v string) error { c.Postgres.SSLKey = v; return nil }},
+	{"REDIS_HOST", "redis-host", "Redis host", func(c *Config, v string) error { c.Redis.Host = v; return nil }},
+	{"REDIS_PORT", "redis-port", "Redis port", func(c *Config, v string) error { return parseInt(v, &c.Redis.Port) }},
+	{"REDIS_PASSWORD", "", "", func(c *Config, v string) error { c.Redis.Password = Secret(v); return nil }},
+	{"REDIS_PASSWORD_FILE", "redis-password-file", "file holding the Redis password", func(c *Config, v string) error { c.Redis.PasswordFile = v; return nil }},
+	{"REDIS_USERNAME", "redis-username", "Redis ACL user", func(c *Config, v string) error { c.Redis.Username = v; return nil }},
+	{"REDIS_TLS", "redis-tls", "connect to Redis over TLS", func(c *Config, v string) error {
+		enabled, err := strconv.ParseBool(v)
+		c.Redis.TLS = enabled
+		return err
+	}},
+	{"REDIS_CA_FILE", "redis-ca-file", "CA certificates verifying the Redis server", func(c *Config, v string) error { c.Redis.CAFile = v; return nil }},
+	{"REDIS_CERT_FILE", "redis-cert-file", "Redis client certificate", func(c *Config, v string) error { c.Redis.CertFile = v; return nil }},
+	{"REDIS_KEY_FILE", "redis-key-file", "Redis client private key", func(c *Config, v string) error { c.Redis.KeyFile = v; return nil }},
+	{"REDIS_DB", "redis-db", "Redis database number", func(c *Config, v string) error { return parseInt(v, &c.Redis.DB) }},
+	{"CACHE_BACKEND", "cache-backend", "cache backend: redis, memory or none", func(c *Config, v string) error { c.Cache.Backend = v; return nil }},
+	{"CACHE_STALE_WHILE_REVALIDATE", "cache-stale-while-revalidate", "serve invalidated values while they are recomputed", func(c *Config, v string) error {
+		swr, err := strconv.ParseBool(v)
+		c.Cache.StaleWhileRevalidate = swr
+		return err
+	}},
+	{"CACHE_TTLS", "cache-ttls", "cache TTLs per kind, e.g. aggregate=30m,splits=2h", func(c *Config, v string) error {
+		ttls, err := nba.ParseCacheTTLs(v)
+		c.Cache.TTLs = ttls
+		return err
+	}},
+	{"CACHE_DISABLED", "cache-disabled", "endpoints whose values are not cached, e.g. /compare,/similar", func(c *Config, v string) error {
+		c.Cache.Disabled = nil
+		for _, endpoint := range strings.Split(v, ",") {
+			if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+				c.Cache.Disabled = append(c.Cache.Disabled, endpoint)
+			}
+		}
+		return nil
+	}},
+	{"CACHE_LOCAL_SIZE", "cache-local-size", "number of values kept in process in front of Redis", func(c *Config, v string) error { return parseInt(v, &c.Cache.LocalSize) }},
+	{"CACHE_LOCAL_TTL", "cache-local-ttl", "TTL of the values kept in process", func(c *Config, v string) error { return parseDuration(v, &c.Cache.LocalTTL) }},
+	{"CACHE_MEMORY_SIZE", "cache-memory-size", "number of values kept by the memory cache backend", func(c *Config, v string) error { return parseInt(v, &c.Cache.MemorySize) }},
+	{"VIEWS_REFRESH_INTERVAL", "views-refresh-interval", "interval between refreshes of the aggregate views", func(c *Config, v string) error {
+		return parseDuration(v, &c.Views.RefreshInterval)
+	}},
+}
+
+// Loader collects the configuration sources: defaults, then the YAML file, then the environment, then the command line flags
+type Loader struct {
+	file  string
+	flags map[string]string
+}
+
+// RegisterFlags registers the -config flag and a flag for every setting that is not a secret
+func (l *Loader) RegisterFlags(fs *flag.FlagSet) {
+	l.flags = make(map[string]string)
+	fs.StringVar(&l.file, "config", os.Getenv("CONFIG_FILE"), "YAML configuration file")
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		name := s.flag
+		fs.Func(name, fmt.Sprintf("%s (env %s)", s.usage, s.env), func(value string) error {
+			l.flags[name] = value
+			return nil
+		})
+	}
+}
+
+// Load returns the validated configuration from all sources, reading the secret files
+func (l *Loader) Load(getenv func(string) string) (*Config, error) {
+	c := Default()
+	if l.file != "" {
+		data, err := os.ReadFile(l.file)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %v", l.file, err)
+		}
+	}
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			if err := s.set(c, value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if value, ok := l.flags[s.flag]; ok {
+			if err := s.set(c, value); err != nil {
+				return nil, fmt.Errorf("invalid -%s: %v", s.flag, err)
+			}
+		}
+	}
+
+	if err := readSecretFile(c.Postgres.PasswordFile, &c.Postgres.Password); err != nil {
+		return nil, err
+	}
+	if err := readSecretFile(c.Redis.PasswordFile, &c.Redis.Password); err != nil {
+		return nil, err
+	}
+	return c, c.Validate()
+}
+
+func readSecretFile(path string, secret *Secret) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read secret file: %v", err)
+	}
+	*secret = Secret(strings.TrimSpace(string(data)))
+	return nil
+}
+
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+func (c *Config) Validate() error {
+	var problems []string
+	if c.Listen == "" {
+		problems = append(problems, "listen address is not set")
+	}
+	if c.DebugListen != "" && c.DebugListen == c.Listen {
+		problems = append(problems, "debugListen must differ from the listen address")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		problems = append(problems, "tls certFile and keyFile must be set together")
+	}
+	if c.TLS.Enabled() && c.TLS.ReloadInterval <= 0 {
+		problems = append(problems, "tls reloadInterval must be positive")
+	}
+	if !contains(server.ClientAuthModes, c.TLS.ClientAuth) {
+		problems = append(problems, fmt.Sprintf("tls clientAuth must be one of %v", server.ClientAuthModes))
+	} else if c.TLS.ClientAuth != server.ClientAuthNone && (!c.TLS.Enabled() || c.TLS.ClientCAFile == "") {
+		problems = append(problems, "tls clientAuth requires a certificate and a clientCAFile")
+	}
+	if c.TLS.RedirectListen != "" && !c.TLS.Enabled() {
+		problems = append(problems, "tls redirectListen requires a certificate")
+	}
+	if c.Auth.Enabled && c.Auth.UsageFlushInterval <= 0 {
+		problems = append(problems, "auth usageFlushInterval must be positive")
+	}
+	if c.Auth.CacheTTL < 0 {
+		problems = append(problems, "auth cacheTTL must not be negative")
+	}
+	switch c.Storage.Backend {
+	case "postgres":
+		if c.Postgres.Host == "" || c.Postgres.User == "" || c.Postgres.Password == "" || c.Postgres.Database == "" {
+			problems = append(problems, "postgres host, user, password and database must be set")
+		}
+		if c.Postgres.Port <= 0 {
+			problems = append(problems, "postgres port must be positive")
+		}
+		if !contains(sslModes, c.Postgres.SSLMode) {
+			problems = append(problems, fmt.Sprintf("postgres sslMode must be one of %v", sslModes))
+		}
+
+		if (c.Postgres.SSLCert == "") != (c.Postgres.SSLKey == "") {
+			problems = append(problems, "postgres sslCert and sslKey must be set together")
+		}
+		if (c.Postgres.SSLRootCert != "" || c.Postgres.SSLCert != "") && c.Postgres.SSLMode == "disable" {
+			problems = append(problems, "postgres certificates require an sslMode other than disable")
+		}
+	case "sqlite":
+		if c.Storage.SQLitePath == "" {
+			problems = append(problems, "storage sqlitePath must be set for the sqlite backend")
+		}
+	default:
+		problems = append(problems, "storage backend must be postgres or sqlite")
+	}
+
+	switch c.Cache.Backend {
+	case "redis":
+		if c.Redis.Host == "" {
+			problems = append(problems, "redis host must be set for the redis cache backend")
+		}
+		if c.Redis.Port <= 0 {
+			problems = append(problems, "redis port must be positive")
+		}
+		if (c.Redis.CertFile == "") != (c.Redis.KeyFile == "") {
+			problems = append(problems, "redis certFile and keyFile must be set together")
+		}
+		if (c.Redis.CAFile != "" || c.Redis.CertFile != "") && !c.Redis.TLS {
+			problems = append(problems, "redis certificates require tls")
+		}
+		if c.Redis.Username != "" && c.Redis.Password == "" {
+			problems = append(problems, "redis username requires a password")
+		}
+	case "memory", "none":
+		if c.Cache.MemorySize <= 0 {
+			problems = append(problems, "cache memorySize must be positive")
+		}
+	default:
+		problems = append(problems, "cache backend must be redis, memory or none")
+	}
+	for kind, ttl := range c.Cache.TTLs {
+		if !contains(nba.CacheKinds, kind) || ttl < 0 {
+			problems = append(problems, fmt.Sprintf("invalid cache TTL %s=%v", kind, ttl))
+		}
+	}
+	for _, endpoint := range c.Cache.Disabled {
+		if !contains(nba.CacheEndpoints, endpoint) {
+			problems = append(problems, fmt.Sprintf("invalid cache disabled endpoint %q, expected one of %v", endpoint, nba.CacheEndpoints))
+		}
+	}
+	if c.Cache.LocalSize < 0 || c.Cache.LocalTTL <= 0 {
+		problems = append(problems, "cache localSize must not be negative and localTTL must be positive")
+	}
+	if c.Views.RefreshInterval <= 0 {
+		problems = append(problems, "views refreshInterval must be positive")
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ConnString returns the PostgreSQL connection URL. A port in the host takes precedence over the port setting.
+func (p PostgresConfig) ConnString() string {
+	host := p.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, strconv.Itoa(p.Port))
+	}
+	u := url.URL{
+		Scheme: "postgresql",
+		User:   url.UserPassword(p.User, string(p.Password)),
+		Host:   host,
+		Path:   "/" + p.Database,
+	}
+	query := url.Values{"sslmode": {p.SSLMode}}
+	for name, value := range map[string]string{"sslrootcert": p.SSLRootCert, "sslcert": p.SSLCert, "sslkey": p.SSLKey} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// CurrentPassword returns the password, read again from the password file if set, so a rotated secret is picked up
+func (p PostgresConfig) CurrentPassword() (string, error) {
+	return currentSecret(p.Password, p.PasswordFile)
+}
+
+func currentSecret(secret Secret, file string) (string, error) {
+	if err := readSecretFile(file, &secret); err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+func (r RedisConfig) Addr() string {
+	return net.JoinHostPort(r.Host, strconv.Itoa(r.Port))
+}
+
+// Credentials returns the user and the password, read again from the password file if set, so a rotated secret is picked up
+func (r RedisConfig) Credentials() (string, string, error) {
+	password, err := currentSecret(r.Password, r.PasswordFile)
+	return r.Username, password, err
+}
+
+// TLSConfig returns the client TLS configuration, nil if TLS is disabled.
+// The client certificate is read on every handshake, so a renewed certificate is used by new connections.
+func (r RedisConfig) TLSConfig() (*tls.Config, error) {
+	if !r.TLS {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: r.Host}
+	if r.CAFile != "" {
+		pem, err := os.ReadFile(r.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read redis CA file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in redis CA file %s", r.CAFile)
+		}
+	}
+	if r.CertFile != "" {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("cannot load redis client certificate: %v", err)
+			}
+			return &cert, nil
+		}
+	}
+	return tlsConfig, nil
+}
+
+// CacheOptions returns the caching options of the statistics. Without a cache backend caching is disabled for all endpoints.
+func (c CacheConfig) CacheOptions() nba.Options {
+	disabled := make(map[string]bool)
+	for _, endpoint := range c.Disabled {
+		disabled[endpoint] = true
+	}
+	if c.Backend == "none" {
+		for _, endpoint := range nba.CacheEndpoints {
+			disabled[endpoint] = true
+		}
+	}
+	return nba.Options{StaleWhileRevalidate: c.StaleWhileRevalidate, CacheTTLs: c.TTLs, CacheDisabled: disabled}
+}
+
+// Print writes the effective configuration as YAML with the secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 
 	"github.com/ShimonMoldawskiy/NBAStatistics/common"
@@ -13,8 +14,24 @@ type PostgresDatabase struct {
 	ctx  context.Context
 }
 
-func NewPostgresDatabase(ctx context.Context, connString string) (*PostgresDatabase, error) {
-	pool, err := pgxpool.Connect(ctx, connString)
+// NewPostgresDatabase connects to the database. If password is set, it is called for every new connection
+// instead of using the password of the connection string, so a rotated password is used without a restart.
+func NewPostgresDatabase(ctx context.Context, connString string, password func() (string, error)) (*PostgresDatabase, error) {
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+	if password != nil {
+		config.BeforeConnect = func(ctx context.Context, connConfig *pgx.ConnConfig) error {
+			current, err := password()
+			if err != nil {
+				return err
+			}
+			connConfig.Password = current
+			return nil
+		}
+	}
+	pool, err := pgxpool.ConnectConfig(ctx, config)
 	if err != nil {
 		return nil, err
 	}
@@ -37,6 +54,27 @@ func (p *PostgresDatabase) Query(query string, args ...interface{}) (common.Rows
 	return p.pool.Query(p.ctx, query, args...)
 }
 
+// InTx runs fn in a transaction, committed if fn succeeds and rolled back otherwise
+func (p *PostgresDatabase) InTx(fn func(tx common.Tx) error) error {
+	return p.pool.BeginFunc(p.ctx, func(tx pgx.Tx) error {
+		return fn(&postgresTx{tx: tx, ctx: p.ctx})
+	})
+}
+
+type postgresTx struct {
+	tx  pgx.Tx
+	ctx context.Context
+}
+
+func (t *postgresTx) Exec(query string, args ...interface{}) error {
+	_, err := t.tx.Exec(t.ctx, query, args...)
+	return err
+}
+
+func (t *postgresTx) Query(query string, args ...interface{}) (common.Rows, error) {
+	return t.tx.Query(t.ctx, query, args...)
+}
+
 func (p *PostgresDatabase) Close() {
 	p.pool.Close()
 }
@@ -0,0 +1,296 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pressly/goose/v3"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/common"
+)
+
+// sqliteDriver is the SQLite driver with the functions of PostgreSQL the statements use
+const sqliteDriver = "sqlite3_nba"
+
+// sqliteTimeFormat is the format of the timestamps stored by SQLite, in UTC, so they compare as text
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("now", func() string { return time.Now().UTC().Format(sqliteTimeFormat) }, false); err != nil {
+				return err
+			}
+			return conn.RegisterFunc("greatest", greatest, true)
+		},
+	})
+}
+
+// greatest returns the largest of the values compared as text, as the timestamps are stored, ignoring NULLs as GREATEST does in PostgreSQL
+func greatest(values ...interface{}) interface{} {
+	var result interface{}
+	for _, value := range values {
+		// The NULL arguments are passed as nil byte slices
+		if blob, ok := value.([]byte); value == nil || ok && blob == nil {
+			continue
+		}
+		if result == nil || fmt.Sprint(value) > fmt.Sprint(result) {
+			result = value
+		}
+	}
+	return result
+}
+
+// sqliteRewrite replaces a construct of the PostgreSQL dialect with its SQLite equivalent
+type sqliteRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// sqliteRewrites rewrite the statements, written for PostgreSQL, to SQLite, in order: the date arithmetic first,
+// as it relies on the casts, then the casts, then the placeholders
+var sqliteRewrites = []sqliteRewrite{
+	// The date some days before a date
+	{regexp.MustCompile(`(CURRENT_DATE|\$\d+::date) - (\$\d+)::int`), `date($1, '-' || $2 || ' days')`},
+	// The days between a date and the previous one
+	{regexp.MustCompile(`(\S+) - LAG\((\S+)\) OVER \(ORDER BY (\S+)\)`), `CAST(julianday($1) - julianday(LAG($2) OVER (ORDER BY $3)) AS INTEGER)`},
+	{regexp.MustCompile(`to_char\(([^,]+), 'YYYY-MM'\)`), `strftime('%Y-%m', $1)`},
+	// Casts bind tighter than the operators, so the multiplication of a float cast applies to the cast operand only
+	{regexp.MustCompile(`::float8\b`), ` * 1.0`},
+	{regexp.MustCompile(`::(int|date|timestamptz)\b`), ``},
+	// A single connection writes at a time, so the lock is always acquired
+	{regexp.MustCompile(`pg_try_advisory_xact_lock\((\$\d+)\)`), `$1 IS NOT NULL`},
+	{regexp.MustCompile(`\$(\d+)`), `?$1`},
+}
+
+// sqliteNoops are the statements with nothing to do in SQLite: the transactions already hold the write lock of the
+// database as they begin, and the aggregate views are plain views, always up to date
+var sqliteNoops = []string{"SELECT pg_advisory_xact_lock(", "LOCK TABLE ", "REFRESH MATERIALIZED VIEW "}
+
+// sqliteQuery returns the statement in the SQLite dialect, or false if there is nothing to run
+func sqliteQuery(query string) (string, bool) {
+	for _, noop := range sqliteNoops {
+		if strings.HasPrefix(query, noop) {
+			return "", false
+		}
+	}
+	for _, rewrite := range sqliteRewrites {
+		query = rewrite.pattern.ReplaceAllString(query, rewrite.replacement)
+	}
+	return query, true
+}
+
+// sqliteArgs converts the arguments SQLite has no type for: the timestamps are stored as UTC text and the arrays as JSON
+func sqliteArgs(args []interface{}) ([]interface{}, error) {
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case time.Time:
+			converted[i] = arg.UTC().Format(sqliteTimeFormat)
+		case []string:
+			values, err := json.Marshal(arg)
+			if err != nil {
+				return nil, err
+			}
+			converted[i] = string(values)
+		default:
+			converted[i] = arg
+		}
+	}
+	return converted, nil
+}
+
+// SQLiteDatabase stores the statistics in a single SQLite file, e.g. on an edge node without a PostgreSQL server.
+// The statements of the service are written for PostgreSQL and rewritten to the SQLite dialect before they run.
+type SQLiteDatabase struct {
+	db *sql.DB
+}
+
+// MigrateSQLite applies the pending migrations of dir, e.g. migrations/sqlite, to the database file, creating it if needed
+func MigrateSQLite(path, dir string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		return err
+	}
+	return goose.Up(db, dir)
+}
+
+// NewSQLiteDatabase opens the database file, which must be migrated with migrations/sqlite
+func NewSQLiteDatabase(path string) (*SQLiteDatabase, error) {
+	// The transactions take the write lock as they begin, so concurrent writers wait for each other instead of
+	// failing to upgrade their read lock
+	db, err := sql.Open(sqliteDriver, "file:"+path+"?_txlock=immediate&_busy_timeout=10000&_journal_mode=WAL&_foreign_keys=on")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteDatabase{db: db}, nil
+}
+
+func (s *SQLiteDatabase) Exec(query string, args ...interface{}) error {
+	return sqliteExec(s.db, query, args)
+}
+
+func (s *SQLiteDatabase) Query(query string, args ...interface{}) (common.Rows, error) {
+	return sqliteQueryRows(s.db, query, args)
+}
+
+// InTx runs fn in a transaction, committed if fn succeeds and rolled back otherwise
+func (s *SQLiteDatabase) InTx(fn func(tx common.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(&sqliteTx{tx: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteDatabase) Close() {
+	s.db.Close()
+}
+
+type sqliteTx struct {
+	tx *sql.Tx
+}
+
+func (t *sqliteTx) Exec(query string, args ...interface{}) error {
+	return sqliteExec(t.tx, query, args)
+}
+
+func (t *sqliteTx) Query(query string, args ...interface{}) (common.Rows, error) {
+	return sqliteQueryRows(t.tx, query, args)
+}
+
+// sqliteConn is the database or the transaction the statements run on
+type sqliteConn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func sqliteExec(conn sqliteConn, query string, args []interface{}) error {
+	query, run := sqliteQuery(query)
+	if !run {
+		return nil
+	}
+	args, err := sqliteArgs(args)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Exec(query, args...)
+	return err
+}
+
+func sqliteQueryRows(conn sqliteConn, query string, args []interface{}) (common.Rows, error) {
+	query, run := sqliteQuery(query)
+	if !run {
+		return nil, fmt.Errorf("statement returns no rows in SQLite: %s", query)
+	}
+	args, err := sqliteArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteRows{rows}, nil
+}
+
+// sqliteRows scans the timestamps and arrays stored as text into their Go types
+type sqliteRows struct {
+	*sql.Rows
+}
+
+func (r *sqliteRows) Close() {
+	r.Rows.Close()
+}
+
+func (r *sqliteRows) Scan(dest ...interface{}) error {
+	converted := make([]interface{}, len(dest))
+	for i, d := range dest {
+		switch d := d.(type) {
+		case *time.Time:
+			converted[i] = sqliteTime{d}
+		case **time.Time:
+			converted[i] = sqliteNullTime{d}
+		case *[]string:
+			converted[i] = sqliteStrings{d}
+		default:
+			converted[i] = d
+		}
+	}
+	return r.Rows.Scan(converted...)
+}
+
+type sqliteTime struct {
+	dest *time.Time
+}
+
+func (t sqliteTime) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case time.Time:
+		*t.dest = src
+		return nil
+	case string:
+		return parseSQLiteTime(src, t.dest)
+	case []byte:
+		return parseSQLiteTime(string(src), t.dest)
+	}
+	return fmt.Errorf("cannot scan %T into a time", src)
+}
+
+func parseSQLiteTime(value string, dest *time.Time) error {
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if parsed, err := time.ParseInLocation(format, value, time.UTC); err == nil {
+			*dest = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid time %q", value)
+}
+
+type sqliteNullTime struct {
+	dest **time.Time
+}
+
+func (t sqliteNullTime) Scan(src interface{}) error {
+	if src == nil {
+		*t.dest = nil
+		return nil
+	}
+	var value time.Time
+	if err := (sqliteTime{&value}).Scan(src); err != nil {
+		return err
+	}
+	*t.dest = &value
+	return nil
+}
+
+type sqliteStrings struct {
+	dest *[]string
+}
+
+func (s sqliteStrings) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(src), s.dest)
+	case []byte:
+		return json.Unmarshal(src, s.dest)
+	}
+	return fmt.Errorf("cannot scan %T into strings", src)
+}
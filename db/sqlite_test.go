@@ -0,0 +1,80 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestSQLiteQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+		run   bool
+	}{
+		{
+			name:  "placeholders and casts",
+			query: "SELECT COUNT(*) FROM records WHERE game_date=$1::date AND created_at <= $2::timestamptz AND id <> $12",
+			want:  "SELECT COUNT(*) FROM records WHERE game_date=?1 AND created_at <= ?2 AND id <> ?12",
+			run:   true,
+		},
+		{
+			name:  "window from the current date",
+			query: "SELECT 1 FROM records r WHERE r.game_date > CURRENT_DATE - $2::int",
+			want:  "SELECT 1 FROM records r WHERE r.game_date > date(CURRENT_DATE, '-' || ?2 || ' days')",
+			run:   true,
+		},
+		{
+			name:  "window as of a date",
+			query: "SELECT 1 FROM records r WHERE r.game_date <= $2::date AND r.game_date > $2::date - $4::int",
+			want:  "SELECT 1 FROM records r WHERE r.game_date <= ?2 AND r.game_date > date(?2, '-' || ?4 || ' days')",
+			run:   true,
+		},
+		{
+			name:  "days of rest",
+			query: "SELECT d.game_date, d.game_date - LAG(d.game_date) OVER (ORDER BY d.game_date) - 1 AS rest FROM d",
+			want:  "SELECT d.game_date, CAST(julianday(d.game_date) - julianday(LAG(d.game_date) OVER (ORDER BY d.game_date)) AS INTEGER) - 1 AS rest FROM d",
+			run:   true,
+		},
+		{
+			name:  "month",
+			query: "SELECT to_char(r.game_date, 'YYYY-MM') AS split FROM r",
+			want:  "SELECT strftime('%Y-%m', r.game_date) AS split FROM r",
+			run:   true,
+		},
+		{
+			name:  "float casts",
+			query: "SELECT t.points::float8 / t.records, COALESCE(AVG(r.points * $1::float8), 0)::float8, ((r.points >= 10)::int + (r.rebounds >= 10)::int) FROM t",
+			want:  "SELECT t.points * 1.0 / t.records, COALESCE(AVG(r.points * ?1 * 1.0), 0) * 1.0, ((r.points >= 10) + (r.rebounds >= 10)) FROM t",
+			run:   true,
+		},
+		{
+			name:  "try lock",
+			query: "SELECT pg_try_advisory_xact_lock($1)",
+			want:  "SELECT ?1 IS NOT NULL",
+			run:   true,
+		},
+		{name: "lock", query: "SELECT pg_advisory_xact_lock($1, $2::date - DATE '2000-01-01')"},
+		{name: "table lock", query: "LOCK TABLE records IN SHARE MODE"},
+		{name: "view refresh", query: "REFRESH MATERIALIZED VIEW CONCURRENTLY player_aggregates"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, run := sqliteQuery(test.query)
+			if run != test.run || got != test.want {
+				t.Errorf("got %q, %v, want %q, %v", got, run, test.want, test.run)
+			}
+		})
+	}
+}
+
+func TestGreatest(t *testing.T) {
+	earlier, later := "2025-01-12 10:00:00", "2025-01-12 10:00:00.5"
+	for _, values := range [][]interface{}{{earlier, later}, {later, earlier}, {[]byte(nil), later}, {later, nil}} {
+		if got := greatest(values...); got != later {
+			t.Errorf("greatest(%v) = %v, want %v", values, got, later)
+		}
+	}
+	if got := greatest([]byte(nil), nil); got != nil {
+		t.Errorf("greatest of NULLs = %v, want NULL", got)
+	}
+}
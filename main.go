@@ -5,22 +5,55 @@ package main
 
 import (
 	"context"
+	"expvar"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	_ "github.com/lib/pq"
 	"github.com/pressly/goose/v3"
 
+	"github.com/ShimonMoldawskiy/NBAStatistics/auth"
 	"github.com/ShimonMoldawskiy/NBAStatistics/cache"
+	"github.com/ShimonMoldawskiy/NBAStatistics/config"
 	"github.com/ShimonMoldawskiy/NBAStatistics/db"
 	"github.com/ShimonMoldawskiy/NBAStatistics/nba"
+	"github.com/ShimonMoldawskiy/NBAStatistics/server"
 )
 
 func main() {
+	backfillEvents := flag.Bool("backfill-events", false, "detect milestone events in the stored records and exit")
+	refreshViews := flag.Bool("refresh-views", false, "refresh the aggregate views, e.g. after a batch import, and exit")
+	rebuildTotals := flag.Bool("rebuild-totals", false, "recompute the running totals of the players and teams from their records and exit")
+	issueKey := flag.String("issue-key", "", "issue an API key with this name and the -key-scopes, print its token and exit")
+	keyScopes := flag.String("key-scopes", auth.ScopeStatsRead, "comma separated scopes of the issued API key: "+strings.Join(auth.Scopes, ", "))
+	revokeKey := flag.Int("revoke-key", 0, "revoke the API key with this ID and exit")
+	listKeys := flag.Bool("list-keys", false, "list the API keys with their usage and exit")
+	var loader config.Loader
+	loader.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	// Load the configuration: defaults, then the YAML file, then the environment, then the flags
+	cfg, err := loader.Load(os.Getenv)
+	if err != nil {
+		log.Fatalf("Unable to load configuration: %v\n", err)
+	}
+	if flag.Arg(0) == "config" {
+		if flag.Arg(1) != "print" {
+			log.Fatalf("Unknown command: %s\n", strings.Join(flag.Args(), " "))
+		}
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatalf("Unable to print configuration: %v\n", err)
+		}
+		return
+	}
+
 	ctx, cfn := context.WithCancelCause(context.Background())
 	defer cfn(nil)
 
@@ -32,42 +65,101 @@ func main() {
 		}
 	}()
 
-	// Initialize db connection, perform migrations if necessary
-	dbHost := os.Getenv("POSTGRES_HOST")
-	dbUser := os.Getenv("POSTGRES_USER")
-	dbPassword := os.Getenv("POSTGRES_PASSWORD")
-	dbName := os.Getenv("POSTGRES_DB")
-
-	if dbHost == "" || dbUser == "" || dbPassword == "" || dbName == "" {
-		log.Fatal("Postgres environment variables are not set")
-	}
-
-	var err error
-	connString := fmt.Sprintf("postgresql://%s:%s@%s/%s?sslmode=disable", dbUser, dbPassword, dbHost, dbName)
-	dbMigration, err := goose.OpenDBWithDriver("postgres", connString)
-	if err != nil {
-		log.Fatalf("Unable to migrate the database: %v\n", err)
-	}
-	dbMigration.Close()
-	db, err := db.NewPostgresDatabase(ctx, connString)
+	// Apply the pending migrations, then initialize db connection
+	db, err := newDatabase(ctx, cfg)
 	if err != nil {
 		log.Fatalf("Unable to connect to database: %v\n", err)
 	}
 	defer db.Close()
 
-	// Initialize cache connection
-	redisHost := os.Getenv("REDIS_HOST")
-	if redisHost == "" {
-		log.Fatal("REDIS_HOST environment variable is not set")
+	if *backfillEvents {
+		count, err := nba.BackfillEvents(db)
+		if err != nil {
+			log.Fatalf("Unable to backfill events: %v\n", err)
+		}
+		log.Printf("Detected %d events\n", count)
+		return
 	}
-	cache, err := cache.NewRedisCache(ctx, redisHost+":6379", "", 0)
+	if *rebuildTotals {
+		if err := nba.RebuildTotals(db); err != nil {
+			log.Fatalf("Unable to rebuild totals: %v\n", err)
+		}
+		log.Println("Rebuilt the running totals")
+		return
+	}
+	if *refreshViews {
+		refreshed, err := nba.RefreshViews(db)
+		if err != nil {
+			log.Fatalf("Unable to refresh aggregate views: %v\n", err)
+		}
+		if !refreshed {
+			log.Println("Aggregate views are being refreshed by another instance")
+		}
+		return
+	}
+
+	// Manage the API keys
+	keys := auth.NewStore(db, cfg.Auth.CacheTTL)
+	if *issueKey != "" {
+		issued, err := keys.Issue(*issueKey, strings.Split(*keyScopes, ","))
+		if err != nil {
+			log.Fatalf("Unable to issue API key: %v\n", err)
+		}
+		log.Printf("Issued API key %d %s with scopes %s; its token is shown only once:\n", issued.ID, issued.Name, strings.Join(issued.Scopes, ","))
+		fmt.Println(issued.Token)
+		return
+	}
+	if *revokeKey != 0 {
+		if err := keys.Revoke(*revokeKey); err != nil {
+			log.Fatalf("Unable to revoke API key: %v\n", err)
+		}
+		log.Printf("Revoked API key %d\n", *revokeKey)
+		return
+	}
+	if *listKeys {
+		list, err := keys.List()
+		if err != nil {
+			log.Fatalf("Unable to list API keys: %v\n", err)
+		}
+		for _, key := range list {
+			status := "active"
+			if key.RevokedAt != nil {
+				status = "revoked " + key.RevokedAt.Format(time.RFC3339)
+			}
+			lastUsed := "never"
+			if key.LastUsedAt != nil {
+				lastUsed = key.LastUsedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%d\t%s\t%s\t%s\trequests %d\tlast used %s\n", key.ID, key.Name, strings.Join(key.Scopes, ","), status, key.Requests, lastUsed)
+		}
+		return
+	}
+
+	// Initialize cache: redis by default, memory or none to run with Postgres only
+	statsCache, err := newCache(ctx, cfg)
 	if err != nil {
 		log.Fatalf("Unable to connect to cache: %v\n", err)
 	}
-	defer cache.Close()
+	defer statsCache.Close()
+
+	// Refresh the aggregate views periodically, skipped while another pod refreshes them
+	go func() {
+		ticker := time.NewTicker(cfg.Views.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := nba.RefreshViews(db); err != nil {
+					log.Printf("Unable to refresh aggregate views: %v\n", err)
+				}
+			}
+		}
+	}()
 
 	// Initialize NBAStatistics
-	nba, err := nba.NewNBAStatistics(cache, db)
+	nba, err := nba.NewNBAStatistics(statsCache, db, cfg.Cache.CacheOptions())
 	if err != nil {
 		log.Fatalf("Unable to initialize statistics: %v\n", err)
 	}
@@ -77,10 +169,144 @@ func main() {
 	r.HandleFunc("/record", nba.AddRecord).Methods("POST")
 	r.HandleFunc("/aggregate/player", nba.GetPlayerAggregate).Methods("GET")
 	r.HandleFunc("/aggregate/team", nba.GetTeamAggregate).Methods("GET")
+	r.HandleFunc("/aggregate/player/splits", nba.GetPlayerSplits).Methods("GET")
+	r.HandleFunc("/aggregate/team/splits", nba.GetTeamSplits).Methods("GET")
 	r.HandleFunc("/aggregate/players", nba.GetAllPlayersAggregate).Methods("GET")
 	r.HandleFunc("/aggregate/teams", nba.GetAllTeamsAggregate).Methods("GET")
+	r.HandleFunc("/series/player", nba.GetPlayerSeries).Methods("GET")
+	r.HandleFunc("/series/team", nba.GetTeamSeries).Methods("GET")
+	r.HandleFunc("/leaders", nba.GetLeaders).Methods("GET")
+	r.HandleFunc("/compare", nba.Compare).Methods("GET")
+	r.HandleFunc("/events", nba.GetEvents).Methods("GET")
+	r.HandleFunc("/fantasy/player", nba.GetPlayerFantasy).Methods("GET")
+	r.HandleFunc("/fantasy/leaders", nba.GetFantasyLeaders).Methods("GET")
+	r.HandleFunc("/projection/player", nba.GetPlayerProjection).Methods("GET")
+	r.HandleFunc("/similar", nba.GetSimilarPlayers).Methods("GET")
+	r.HandleFunc("/players/search", nba.Search).Methods("GET")
+	r.HandleFunc("/standings", nba.GetStandings).Methods("GET")
+	r.HandleFunc("/ratings", nba.GetRatings).Methods("GET")
+	r.HandleFunc("/ratings/history", nba.GetRatingHistory).Methods("GET")
+	r.HandleFunc("/ratings/predict", nba.PredictGame).Methods("GET")
 
-	// Start server
-	log.Println("Server started at :8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	// Serve the metrics on the internal listener, if any, which is not exposed with the API
+	if cfg.DebugListen != "" {
+		go func() {
+			debug := http.NewServeMux()
+			debug.Handle("/debug/vars", expvar.Handler())
+			log.Printf("Debug server started at %s\n", cfg.DebugListen)
+			log.Fatal(http.ListenAndServe(cfg.DebugListen, debug))
+		}()
+	}
+
+	// Require API keys if enabled. The admin and debug endpoints are only served with authentication.
+	if cfg.Auth.Enabled {
+		r.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+		r.HandleFunc("/admin/keys", keys.IssueKey).Methods("POST")
+		r.HandleFunc("/admin/keys", keys.ListKeys).Methods("GET")
+		r.HandleFunc("/admin/keys", keys.RevokeKey).Methods("DELETE")
+		r.Use(keys.Middleware(requiredScope))
+		go keys.FlushUsagePeriodically(ctx, cfg.Auth.UsageFlushInterval)
+	} else {
+		log.Println("WARNING: authentication is disabled, anyone reaching the server can read the statistics and add records. Set AUTH_ENABLED=true to require API keys.")
+	}
+
+	// Start server, over HTTPS if a certificate is configured
+	if !cfg.TLS.Enabled() {
+		log.Printf("Server started at %s\n", cfg.Listen)
+		log.Fatal(http.ListenAndServe(cfg.Listen, r))
+	}
+	srv, err := newTLSServer(ctx, cfg.TLS, cfg.Listen, r)
+	if err != nil {
+		log.Fatalf("Unable to configure TLS: %v\n", err)
+	}
+	if cfg.TLS.RedirectListen != "" {
+		go func() {
+			log.Printf("Redirecting HTTP at %s to HTTPS\n", cfg.TLS.RedirectListen)
+			log.Fatal(http.ListenAndServe(cfg.TLS.RedirectListen, server.RedirectHandler(cfg.Listen)))
+		}()
+	}
+	log.Printf("Server started at %s over HTTPS\n", cfg.Listen)
+	log.Fatal(srv.ListenAndServeTLS("", ""))
+}
+
+// requiredScope returns the API key scope of a request: admin for the admin and debug endpoints,
+// records:write to add records and stats:read for the statistics
+func requiredScope(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/admin/"), strings.HasPrefix(r.URL.Path, "/debug/"):
+		return auth.ScopeAdmin
+	case r.Method == http.MethodPost:
+		return auth.ScopeRecordsWrite
+	default:
+		return auth.ScopeStatsRead
+	}
+}
+
+// newTLSServer returns the HTTPS server, reloading its certificate when the files change
+func newTLSServer(ctx context.Context, cfg config.TLSConfig, addr string, handler http.Handler) (*http.Server, error) {
+	reloader, err := server.NewCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.Watch(ctx, cfg.ReloadInterval)
+
+	tlsConfig, err := server.NewTLSConfig(reloader, cfg.ClientAuth, cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}, nil
+}
+
+// newDatabase migrates and opens the database of the storage backend: postgres, or sqlite for a local file
+func newDatabase(ctx context.Context, cfg *config.Config) (nba.Database, error) {
+	if cfg.Storage.Backend == "sqlite" {
+		if err := db.MigrateSQLite(cfg.Storage.SQLitePath, "migrations/sqlite"); err != nil {
+			return nil, fmt.Errorf("unable to migrate the database: %v", err)
+		}
+		return db.NewSQLiteDatabase(cfg.Storage.SQLitePath)
+	}
+
+	connString := cfg.Postgres.ConnString()
+	dbMigration, err := goose.OpenDBWithDriver("postgres", connString)
+	if err != nil {
+		return nil, fmt.Errorf("unable to migrate the database: %v", err)
+	}
+	err = goose.Up(dbMigration, "migrations")
+	dbMigration.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to migrate the database: %v", err)
+	}
+	return db.NewPostgresDatabase(ctx, connString, cfg.Postgres.CurrentPassword)
+}
+
+// newCache connects to the cache backend: redis, optionally with an in-process tier in front of it, memory or none
+func newCache(ctx context.Context, cfg *config.Config) (nba.Cache, error) {
+	if cfg.Cache.Backend != "redis" {
+		return cache.NewMemoryCache(cfg.Cache.MemorySize), nil
+	}
+
+	tlsConfig, err := cfg.Redis.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	redisCache, err := cache.NewRedisCache(ctx, cache.RedisOptions{
+		Addr:        cfg.Redis.Addr(),
+		DB:          cfg.Redis.DB,
+		Credentials: cfg.Redis.Credentials,
+		TLSConfig:   tlsConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the hottest values in process in front of Redis if enabled
+	if cfg.Cache.LocalSize == 0 {
+		return redisCache, nil
+	}
+	tieredCache, err := cache.NewTieredCache(redisCache, cfg.Cache.LocalSize, cfg.Cache.LocalTTL)
+	if err != nil {
+		redisCache.Close()
+		return nil, err
+	}
+	return tieredCache, nil
 }
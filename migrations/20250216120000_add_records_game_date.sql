@@ -0,0 +1,20 @@
+-- +goose Up
+-- The game dates of the records stored before are unknown, so they are left NULL; new records require one,
+-- defaulting to the date they are stored
+-- +goose StatementBegin
+ALTER TABLE records ADD COLUMN game_date DATE;
+ALTER TABLE records ALTER COLUMN game_date SET DEFAULT CURRENT_DATE;
+ALTER TABLE records ADD CONSTRAINT records_game_date_not_null CHECK (game_date IS NOT NULL) NOT VALID;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX records_player_id_game_date_idx ON records (player_id, game_date);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS records_player_id_game_date_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE records DROP COLUMN IF EXISTS game_date;
+-- +goose StatementEnd
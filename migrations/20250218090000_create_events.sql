@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE events (
+    id SERIAL PRIMARY KEY,
+    player_id INTEGER REFERENCES players(id),
+    record_id INTEGER REFERENCES records(id),
+    type      VARCHAR(50) NOT NULL,
+    value     INTEGER NOT NULL,
+    game_date DATE NOT NULL,
+    UNIQUE (record_id, type, value)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX events_player_id_game_date_idx ON events (player_id, game_date);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS events;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE fantasy_profiles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE,
+    points    FLOAT NOT NULL DEFAULT 0,
+    rebounds  FLOAT NOT NULL DEFAULT 0,
+    assists   FLOAT NOT NULL DEFAULT 0,
+    steals    FLOAT NOT NULL DEFAULT 0,
+    blocks    FLOAT NOT NULL DEFAULT 0,
+    turnovers FLOAT NOT NULL DEFAULT 0,
+    fouls     FLOAT NOT NULL DEFAULT 0,
+    minutes   FLOAT NOT NULL DEFAULT 0,
+    double_double_bonus FLOAT NOT NULL DEFAULT 0,
+    triple_double_bonus FLOAT NOT NULL DEFAULT 0
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO fantasy_profiles (name, points, rebounds, assists, steals, blocks, turnovers, double_double_bonus, triple_double_bonus)
+    VALUES ('standard', 1, 1.25, 1.5, 2, 2, -0.5, 1.5, 3);
+INSERT INTO fantasy_profiles (name, points, rebounds, assists, steals, blocks, turnovers)
+    VALUES ('points', 1, 1.2, 1.5, 3, 3, -1);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS fantasy_profiles;
+-- +goose StatementEnd
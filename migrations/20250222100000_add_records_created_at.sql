@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE records ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+-- +goose StatementEnd
+
+-- Records without a game date keep the time of the migration
+-- +goose StatementBegin
+UPDATE records SET created_at = game_date WHERE game_date IS NOT NULL;
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE records DROP COLUMN IF EXISTS created_at;
+-- +goose StatementEnd
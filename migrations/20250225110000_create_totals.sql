@@ -0,0 +1,54 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE player_totals (
+    player_id INTEGER PRIMARY KEY REFERENCES players(id),
+    games     INTEGER NOT NULL DEFAULT 0,
+    points    BIGINT NOT NULL DEFAULT 0,
+    rebounds  BIGINT NOT NULL DEFAULT 0,
+    assists   BIGINT NOT NULL DEFAULT 0,
+    steals    BIGINT NOT NULL DEFAULT 0,
+    blocks    BIGINT NOT NULL DEFAULT 0,
+    turnovers BIGINT NOT NULL DEFAULT 0,
+    fouls     BIGINT NOT NULL DEFAULT 0,
+    minutes   FLOAT NOT NULL DEFAULT 0,
+    double_doubles INTEGER NOT NULL DEFAULT 0,
+    triple_doubles INTEGER NOT NULL DEFAULT 0
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE team_totals (
+    team_id   INTEGER PRIMARY KEY REFERENCES teams(id),
+    games     INTEGER NOT NULL DEFAULT 0,
+    points    BIGINT NOT NULL DEFAULT 0,
+    rebounds  BIGINT NOT NULL DEFAULT 0,
+    assists   BIGINT NOT NULL DEFAULT 0,
+    steals    BIGINT NOT NULL DEFAULT 0,
+    blocks    BIGINT NOT NULL DEFAULT 0,
+    turnovers BIGINT NOT NULL DEFAULT 0,
+    fouls     BIGINT NOT NULL DEFAULT 0,
+    minutes   FLOAT NOT NULL DEFAULT 0,
+    double_doubles INTEGER NOT NULL DEFAULT 0,
+    triple_doubles INTEGER NOT NULL DEFAULT 0
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO player_totals (player_id, games, points, rebounds, assists, steals, blocks, turnovers, fouls, minutes, double_doubles, triple_doubles)
+    SELECT r.player_id, COUNT(r.id), SUM(r.points), SUM(r.rebounds), SUM(r.assists), SUM(r.steals), SUM(r.blocks), SUM(r.turnovers), SUM(r.fouls), SUM(r.minutes),
+        COUNT(r.id) FILTER (WHERE (r.points >= 10)::int + (r.rebounds >= 10)::int + (r.assists >= 10)::int + (r.steals >= 10)::int + (r.blocks >= 10)::int >= 2),
+        COUNT(r.id) FILTER (WHERE (r.points >= 10)::int + (r.rebounds >= 10)::int + (r.assists >= 10)::int + (r.steals >= 10)::int + (r.blocks >= 10)::int >= 3)
+    FROM records r GROUP BY r.player_id;
+INSERT INTO team_totals (team_id, games, points, rebounds, assists, steals, blocks, turnovers, fouls, minutes, double_doubles, triple_doubles)
+    SELECT p.team_id, SUM(t.games), SUM(t.points), SUM(t.rebounds), SUM(t.assists), SUM(t.steals), SUM(t.blocks), SUM(t.turnovers), SUM(t.fouls), SUM(t.minutes),
+        SUM(t.double_doubles), SUM(t.triple_doubles)
+    FROM player_totals t JOIN players p ON t.player_id = p.id GROUP BY p.team_id;
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS team_totals;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS player_totals;
+-- +goose StatementEnd
@@ -0,0 +1,53 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE MATERIALIZED VIEW player_aggregates AS
+    SELECT p.id, COUNT(r.id) AS games, COALESCE(AVG(r.points), 0) AS points, COALESCE(AVG(r.rebounds), 0) AS rebounds, COALESCE(AVG(r.assists), 0) AS assists,
+        COALESCE(AVG(r.steals), 0) AS steals, COALESCE(AVG(r.blocks), 0) AS blocks, COALESCE(AVG(r.turnovers), 0) AS turnovers, COALESCE(AVG(r.fouls), 0) AS fouls,
+        COALESCE(AVG(r.minutes), 0) AS minutes,
+        COUNT(r.id) FILTER (WHERE (r.points >= 10)::int + (r.rebounds >= 10)::int + (r.assists >= 10)::int + (r.steals >= 10)::int + (r.blocks >= 10)::int >= 2) AS double_doubles,
+        COUNT(r.id) FILTER (WHERE (r.points >= 10)::int + (r.rebounds >= 10)::int + (r.assists >= 10)::int + (r.steals >= 10)::int + (r.blocks >= 10)::int >= 3) AS triple_doubles
+    FROM players p LEFT JOIN records r ON r.player_id = p.id
+    GROUP BY p.id;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX player_aggregates_id_idx ON player_aggregates (id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE MATERIALIZED VIEW team_aggregates AS
+    SELECT t.id, COUNT(r.id) AS games, COALESCE(AVG(r.points), 0) AS points, COALESCE(AVG(r.rebounds), 0) AS rebounds, COALESCE(AVG(r.assists), 0) AS assists,
+        COALESCE(AVG(r.steals), 0) AS steals, COALESCE(AVG(r.blocks), 0) AS blocks, COALESCE(AVG(r.turnovers), 0) AS turnovers, COALESCE(AVG(r.fouls), 0) AS fouls,
+        COALESCE(AVG(r.minutes), 0) AS minutes,
+        COUNT(r.id) FILTER (WHERE (r.points >= 10)::int + (r.rebounds >= 10)::int + (r.assists >= 10)::int + (r.steals >= 10)::int + (r.blocks >= 10)::int >= 2) AS double_doubles,
+        COUNT(r.id) FILTER (WHERE (r.points >= 10)::int + (r.rebounds >= 10)::int + (r.assists >= 10)::int + (r.steals >= 10)::int + (r.blocks >= 10)::int >= 3) AS triple_doubles
+    FROM teams t LEFT JOIN players p ON p.team_id = t.id LEFT JOIN records r ON r.player_id = p.id
+    GROUP BY t.id;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX team_aggregates_id_idx ON team_aggregates (id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE aggregate_view_refreshes (
+    view_name    VARCHAR(100) PRIMARY KEY,
+    refreshed_at TIMESTAMPTZ NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO aggregate_view_refreshes (view_name, refreshed_at) VALUES ('player_aggregates', now()), ('team_aggregates', now());
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS aggregate_view_refreshes;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP MATERIALIZED VIEW IF EXISTS team_aggregates;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP MATERIALIZED VIEW IF EXISTS player_aggregates;
+-- +goose StatementEnd
@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE player_totals ADD COLUMN records INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE team_totals ADD COLUMN records INTEGER NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE player_totals SET records = games;
+UPDATE team_totals t SET records = t.games, games = (
+    SELECT COUNT(DISTINCT r.game_date) FROM records r JOIN players p ON r.player_id = p.id WHERE p.team_id = t.team_id
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP MATERIALIZED VIEW IF EXISTS team_aggregates;
+CREATE MATERIALIZED VIEW team_aggregates AS
+    SELECT t.id, COUNT(DISTINCT r.game_date) AS games, COALESCE(AVG(r.points), 0) AS points, COALESCE(AVG(r.rebounds), 0) AS rebounds, COALESCE(AVG(r.assists), 0) AS assists,
+        COALESCE(AVG(r.steals), 0) AS steals, COALESCE(AVG(r.blocks), 0) AS blocks, COALESCE(AVG(r.turnovers), 0) AS turnovers, COALESCE(AVG(r.fouls), 0) AS fouls,
+        COALESCE(AVG(r.minutes), 0) AS minutes,
+        COUNT(r.id) FILTER (WHERE (r.points >= 10)::int + (r.rebounds >= 10)::int + (r.assists >= 10)::int + (r.steals >= 10)::int + (r.blocks >= 10)::int >= 2) AS double_doubles,
+        COUNT(r.id) FILTER (WHERE (r.points >= 10)::int + (r.rebounds >= 10)::int + (r.assists >= 10)::int + (r.steals >= 10)::int + (r.blocks >= 10)::int >= 3) AS triple_doubles
+    FROM teams t LEFT JOIN players p ON p.team_id = t.id LEFT JOIN records r ON r.player_id = p.id
+    GROUP BY t.id;
+CREATE UNIQUE INDEX team_aggregates_id_idx ON team_aggregates (id);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP MATERIALIZED VIEW IF EXISTS team_aggregates;
+CREATE MATERIALIZED VIEW team_aggregates AS
+    SELECT t.id, COUNT(r.id) AS games, COALESCE(AVG(r.points), 0) AS points, COALESCE(AVG(r.rebounds), 0) AS rebounds, COALESCE(AVG(r.assists), 0) AS assists,
+        COALESCE(AVG(r.steals), 0) AS steals, COALESCE(AVG(r.blocks), 0) AS blocks, COALESCE(AVG(r.turnovers), 0) AS turnovers, COALESCE(AVG(r.fouls), 0) AS fouls,
+        COALESCE(AVG(r.minutes), 0) AS minutes,
+        COUNT(r.id) FILTER (WHERE (r.points >= 10)::int + (r.rebounds >= 10)::int + (r.assists >= 10)::int + (r.steals >= 10)::int + (r.blocks >= 10)::int >= 2) AS double_doubles,
+        COUNT(r.id) FILTER (WHERE (r.points >= 10)::int + (r.rebounds >= 10)::int + (r.assists >= 10)::int + (r.steals >= 10)::int + (r.blocks >= 10)::int >= 3) AS triple_doubles
+    FROM teams t LEFT JOIN players p ON p.team_id = t.id LEFT JOIN records r ON r.player_id = p.id
+    GROUP BY t.id;
+CREATE UNIQUE INDEX team_aggregates_id_idx ON team_aggregates (id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE team_totals SET games = records;
+ALTER TABLE team_totals DROP COLUMN records;
+ALTER TABLE player_totals DROP COLUMN records;
+-- +goose StatementEnd
@@ -0,0 +1,47 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE games (
+    id SERIAL PRIMARY KEY,
+    game_date DATE NOT NULL,
+    home_team_id INTEGER NOT NULL REFERENCES teams(id),
+    away_team_id INTEGER NOT NULL REFERENCES teams(id),
+    CHECK (home_team_id <> away_team_id),
+    UNIQUE (game_date, home_team_id, away_team_id)
+);
+-- +goose StatementEnd
+
+-- Records stored without their opponent have no game
+-- +goose StatementBegin
+ALTER TABLE records ADD COLUMN game_id INTEGER REFERENCES games(id);
+CREATE INDEX records_game_id_idx ON records (game_id);
+-- +goose StatementEnd
+
+-- team_games has a row per game and side: the team, its opponent, whether it played at home, the points of both teams
+-- summed over their players' records, and the result of the team, W or L, NULL while the points are tied
+-- +goose StatementBegin
+CREATE VIEW team_games AS
+    WITH sides AS (
+        SELECT g.id AS game_id, g.game_date, g.home_team_id AS team_id, g.away_team_id AS opponent_id, TRUE AS home FROM games g
+        UNION ALL
+        SELECT g.id, g.game_date, g.away_team_id, g.home_team_id, FALSE FROM games g
+    ), points AS (
+        SELECT r.game_id, p.team_id, SUM(r.points) AS points FROM records r JOIN players p ON r.player_id = p.id WHERE r.game_id IS NOT NULL GROUP BY r.game_id, p.team_id
+    )
+    SELECT s.game_id, s.game_date, s.team_id, s.opponent_id, s.home, COALESCE(t.points, 0) AS points, COALESCE(o.points, 0) AS opponent_points,
+        CASE WHEN COALESCE(t.points, 0) > COALESCE(o.points, 0) THEN 'W' WHEN COALESCE(t.points, 0) < COALESCE(o.points, 0) THEN 'L' END AS result
+    FROM sides s
+    LEFT JOIN points t ON t.game_id = s.game_id AND t.team_id = s.team_id
+    LEFT JOIN points o ON o.game_id = s.game_id AND o.team_id = s.opponent_id;
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP VIEW IF EXISTS team_games;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE records DROP COLUMN IF EXISTS game_id;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS games;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE teams ADD COLUMN conference TEXT;
+ALTER TABLE teams ADD COLUMN division TEXT;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE teams SET conference = 'West', division = 'Pacific' WHERE name IN ('Lakers', 'Warriors');
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE teams DROP COLUMN IF EXISTS division;
+ALTER TABLE teams DROP COLUMN IF EXISTS conference;
+-- +goose StatementEnd
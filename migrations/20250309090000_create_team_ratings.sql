@@ -0,0 +1,19 @@
+-- +goose Up
+-- team_ratings is the Elo rating history of the teams, a row per game and side with the rating before and after the game.
+-- It is recomputed from all games whenever the ratings are, after a record of a game.
+-- +goose StatementBegin
+CREATE TABLE team_ratings (
+    game_id INTEGER NOT NULL REFERENCES games(id),
+    team_id INTEGER NOT NULL REFERENCES teams(id),
+    game_date DATE NOT NULL,
+    opponent_id INTEGER NOT NULL REFERENCES teams(id),
+    rating_before DOUBLE PRECISION NOT NULL,
+    rating DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (game_id, team_id)
+);
+CREATE INDEX team_ratings_team_id_idx ON team_ratings (team_id, game_date);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS team_ratings;
+-- +goose StatementEnd
@@ -0,0 +1,209 @@
+-- +goose Up
+-- The schema of the SQLite storage backend, matching the PostgreSQL migrations up to 20250309090000. The dates and
+-- timestamps are stored as UTC text, the scopes of the API keys as a JSON array, and the aggregate views are plain views.
+-- +goose StatementBegin
+CREATE TABLE teams (
+    id INTEGER PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    conference TEXT,
+    division TEXT
+);
+INSERT INTO teams (name, conference, division) VALUES ('Lakers', 'West', 'Pacific');
+INSERT INTO teams (name, conference, division) VALUES ('Warriors', 'West', 'Pacific');
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE players (
+    id INTEGER PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    team_id INTEGER REFERENCES teams(id)
+);
+INSERT INTO players (name, team_id) VALUES ('Lebron James', 1);
+INSERT INTO players (name, team_id) VALUES ('Anthony Davis', 1);
+INSERT INTO players (name, team_id) VALUES ('Stephen Curry', 2);
+INSERT INTO players (name, team_id) VALUES ('Klay Thompson', 2);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE games (
+    id INTEGER PRIMARY KEY,
+    game_date DATE NOT NULL,
+    home_team_id INTEGER NOT NULL REFERENCES teams(id),
+    away_team_id INTEGER NOT NULL REFERENCES teams(id),
+    CHECK (home_team_id <> away_team_id),
+    UNIQUE (game_date, home_team_id, away_team_id)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE records (
+    id INTEGER PRIMARY KEY,
+    player_id INTEGER REFERENCES players(id),
+    points    INTEGER,
+    rebounds  INTEGER,
+    assists   INTEGER,
+    steals    INTEGER,
+    blocks    INTEGER,
+    turnovers INTEGER,
+    fouls     INTEGER,
+    minutes   FLOAT,
+    game_date DATE NOT NULL DEFAULT CURRENT_DATE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    game_id INTEGER REFERENCES games(id)
+);
+CREATE INDEX records_player_id_game_date_idx ON records (player_id, game_date);
+CREATE INDEX records_game_id_idx ON records (game_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE events (
+    id INTEGER PRIMARY KEY,
+    player_id INTEGER REFERENCES players(id),
+    record_id INTEGER REFERENCES records(id),
+    type      VARCHAR(50) NOT NULL,
+    value     INTEGER NOT NULL,
+    game_date DATE NOT NULL,
+    UNIQUE (record_id, type, value)
+);
+CREATE INDEX events_player_id_game_date_idx ON events (player_id, game_date);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE fantasy_profiles (
+    id INTEGER PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE,
+    points    FLOAT NOT NULL DEFAULT 0,
+    rebounds  FLOAT NOT NULL DEFAULT 0,
+    assists   FLOAT NOT NULL DEFAULT 0,
+    steals    FLOAT NOT NULL DEFAULT 0,
+    blocks    FLOAT NOT NULL DEFAULT 0,
+    turnovers FLOAT NOT NULL DEFAULT 0,
+    fouls     FLOAT NOT NULL DEFAULT 0,
+    minutes   FLOAT NOT NULL DEFAULT 0,
+    double_double_bonus FLOAT NOT NULL DEFAULT 0,
+    triple_double_bonus FLOAT NOT NULL DEFAULT 0
+);
+INSERT INTO fantasy_profiles (name, points, rebounds, assists, steals, blocks, turnovers, double_double_bonus, triple_double_bonus)
+    VALUES ('standard', 1, 1.25, 1.5, 2, 2, -0.5, 1.5, 3);
+INSERT INTO fantasy_profiles (name, points, rebounds, assists, steals, blocks, turnovers)
+    VALUES ('points', 1, 1.2, 1.5, 3, 3, -1);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE player_totals (
+    player_id INTEGER PRIMARY KEY REFERENCES players(id),
+    games     INTEGER NOT NULL DEFAULT 0,
+    records   INTEGER NOT NULL DEFAULT 0,
+    points    BIGINT NOT NULL DEFAULT 0,
+    rebounds  BIGINT NOT NULL DEFAULT 0,
+    assists   BIGINT NOT NULL DEFAULT 0,
+    steals    BIGINT NOT NULL DEFAULT 0,
+    blocks    BIGINT NOT NULL DEFAULT 0,
+    turnovers BIGINT NOT NULL DEFAULT 0,
+    fouls     BIGINT NOT NULL DEFAULT 0,
+    minutes   FLOAT NOT NULL DEFAULT 0,
+    double_doubles INTEGER NOT NULL DEFAULT 0,
+    triple_doubles INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE team_totals (
+    team_id   INTEGER PRIMARY KEY REFERENCES teams(id),
+    games     INTEGER NOT NULL DEFAULT 0,
+    records   INTEGER NOT NULL DEFAULT 0,
+    points    BIGINT NOT NULL DEFAULT 0,
+    rebounds  BIGINT NOT NULL DEFAULT 0,
+    assists   BIGINT NOT NULL DEFAULT 0,
+    steals    BIGINT NOT NULL DEFAULT 0,
+    blocks    BIGINT NOT NULL DEFAULT 0,
+    turnovers BIGINT NOT NULL DEFAULT 0,
+    fouls     BIGINT NOT NULL DEFAULT 0,
+    minutes   FLOAT NOT NULL DEFAULT 0,
+    double_doubles INTEGER NOT NULL DEFAULT 0,
+    triple_doubles INTEGER NOT NULL DEFAULT 0
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE VIEW player_aggregates AS
+    SELECT p.id, COUNT(r.id) AS games, COALESCE(AVG(r.points), 0) AS points, COALESCE(AVG(r.rebounds), 0) AS rebounds, COALESCE(AVG(r.assists), 0) AS assists,
+        COALESCE(AVG(r.steals), 0) AS steals, COALESCE(AVG(r.blocks), 0) AS blocks, COALESCE(AVG(r.turnovers), 0) AS turnovers, COALESCE(AVG(r.fouls), 0) AS fouls,
+        COALESCE(AVG(r.minutes), 0) AS minutes,
+        COUNT(r.id) FILTER (WHERE (r.points >= 10) + (r.rebounds >= 10) + (r.assists >= 10) + (r.steals >= 10) + (r.blocks >= 10) >= 2) AS double_doubles,
+        COUNT(r.id) FILTER (WHERE (r.points >= 10) + (r.rebounds >= 10) + (r.assists >= 10) + (r.steals >= 10) + (r.blocks >= 10) >= 3) AS triple_doubles
+    FROM players p LEFT JOIN records r ON r.player_id = p.id
+    GROUP BY p.id;
+CREATE VIEW team_aggregates AS
+    SELECT t.id, COUNT(DISTINCT r.game_date) AS games, COALESCE(AVG(r.points), 0) AS points, COALESCE(AVG(r.rebounds), 0) AS rebounds, COALESCE(AVG(r.assists), 0) AS assists,
+        COALESCE(AVG(r.steals), 0) AS steals, COALESCE(AVG(r.blocks), 0) AS blocks, COALESCE(AVG(r.turnovers), 0) AS turnovers, COALESCE(AVG(r.fouls), 0) AS fouls,
+        COALESCE(AVG(r.minutes), 0) AS minutes,
+        COUNT(r.id) FILTER (WHERE (r.points >= 10) + (r.rebounds >= 10) + (r.assists >= 10) + (r.steals >= 10) + (r.blocks >= 10) >= 2) AS double_doubles,
+        COUNT(r.id) FILTER (WHERE (r.points >= 10) + (r.rebounds >= 10) + (r.assists >= 10) + (r.steals >= 10) + (r.blocks >= 10) >= 3) AS triple_doubles
+    FROM teams t LEFT JOIN players p ON p.team_id = t.id LEFT JOIN records r ON r.player_id = p.id
+    GROUP BY t.id;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE aggregate_view_refreshes (
+    view_name    VARCHAR(100) PRIMARY KEY,
+    refreshed_at TIMESTAMP NOT NULL
+);
+INSERT INTO aggregate_view_refreshes (view_name, refreshed_at) VALUES ('player_aggregates', CURRENT_TIMESTAMP), ('team_aggregates', CURRENT_TIMESTAMP);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE api_keys (
+    id INTEGER PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    requests BIGINT NOT NULL DEFAULT 0
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE VIEW team_games AS
+    WITH sides AS (
+        SELECT g.id AS game_id, g.game_date, g.home_team_id AS team_id, g.away_team_id AS opponent_id, TRUE AS home FROM games g
+        UNION ALL
+        SELECT g.id, g.game_date, g.away_team_id, g.home_team_id, FALSE FROM games g
+    ), points AS (
+        SELECT r.game_id, p.team_id, SUM(r.points) AS points FROM records r JOIN players p ON r.player_id = p.id WHERE r.game_id IS NOT NULL GROUP BY r.game_id, p.team_id
+    )
+    SELECT s.game_id, s.game_date, s.team_id, s.opponent_id, s.home, COALESCE(t.points, 0) AS points, COALESCE(o.points, 0) AS opponent_points,
+        CASE WHEN COALESCE(t.points, 0) > COALESCE(o.points, 0) THEN 'W' WHEN COALESCE(t.points, 0) < COALESCE(o.points, 0) THEN 'L' END AS result
+    FROM sides s
+    LEFT JOIN points t ON t.game_id = s.game_id AND t.team_id = s.team_id
+    LEFT JOIN points o ON o.game_id = s.game_id AND o.team_id = s.opponent_id;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE team_ratings (
+    game_id INTEGER NOT NULL REFERENCES games(id),
+    team_id INTEGER NOT NULL REFERENCES teams(id),
+    game_date DATE NOT NULL,
+    opponent_id INTEGER NOT NULL REFERENCES teams(id),
+    rating_before DOUBLE PRECISION NOT NULL,
+    rating DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (game_id, team_id)
+);
+CREATE INDEX team_ratings_team_id_idx ON team_ratings (team_id, game_date);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS team_ratings;
+DROP VIEW IF EXISTS team_games;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS aggregate_view_refreshes;
+DROP VIEW IF EXISTS team_aggregates;
+DROP VIEW IF EXISTS player_aggregates;
+DROP TABLE IF EXISTS team_totals;
+DROP TABLE IF EXISTS player_totals;
+DROP TABLE IF EXISTS fantasy_profiles;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS records;
+DROP TABLE IF EXISTS games;
+DROP TABLE IF EXISTS players;
+DROP TABLE IF EXISTS teams;
+-- +goose StatementEnd
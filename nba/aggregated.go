@@ -1,8 +1,17 @@
 package nba
 
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/common"
+)
+
 type AggregatedRecord struct {
 	ID        int     `json:"id"`
 	Name      string  `json:"name"`
+	Games     int     `json:"games"`
 	Points    float64 `json:"points"`
 	Rebounds  float64 `json:"rebounds"`
 	Assists   float64 `json:"assists"`
@@ -11,10 +20,254 @@ type AggregatedRecord struct {
 	Turnovers float64 `json:"turnovers"`
 	Fouls     float64 `json:"fouls"`
 	Minutes   float64 `json:"minutes"`
+
+	DoubleDoubles int `json:"doubleDoubles"`
+	TripleDoubles int `json:"tripleDoubles"`
+}
+
+// Stat returns the value of the statistic with the given JSON name
+func (a *AggregatedRecord) Stat(name string) (float64, bool) {
+	switch name {
+	case "games":
+		return float64(a.Games), true
+	case "points":
+		return a.Points, true
+	case "rebounds":
+		return a.Rebounds, true
+	case "assists":
+		return a.Assists, true
+	case "steals":
+		return a.Steals, true
+	case "blocks":
+		return a.Blocks, true
+	case "turnovers":
+		return a.Turnovers, true
+	case "fouls":
+		return a.Fouls, true
+	case "minutes":
+		return a.Minutes, true
+	case "doubleDoubles":
+		return float64(a.DoubleDoubles), true
+	case "tripleDoubles":
+		return float64(a.TripleDoubles), true
+	}
+	return 0, false
+}
+
+var AggregatedStats = []string{"games", "points", "rebounds", "assists", "steals", "blocks", "turnovers", "fouls", "minutes", "doubleDoubles", "tripleDoubles"}
+
+// RecordStats are the statistics stored per record, in the order of the records table columns
+var RecordStats = []string{"points", "rebounds", "assists", "steals", "blocks", "turnovers", "fouls", "minutes"}
+
+func isRecordStat(stat string) bool {
+	for _, s := range RecordStats {
+		if s == stat {
+			return true
+		}
+	}
+	return false
+}
+
+type Window string
+
+const (
+	WindowAll        Window = ""
+	WindowLast5      Window = "last5"
+	WindowLast10     Window = "last10"
+	WindowLast30Days Window = "last30days"
+)
+
+var Windows = []Window{WindowAll, WindowLast5, WindowLast10, WindowLast30Days}
+
+func ParseWindow(s string) (Window, error) {
+	for _, w := range Windows {
+		if Window(s) == w {
+			return w, nil
+		}
+	}
+	return WindowAll, fmt.Errorf("invalid window %q", s)
+}
+
+// lastGames returns the number of most recent games the window is limited to, 0 if not limited
+func (w Window) lastGames() int {
+	switch w {
+	case WindowLast5:
+		return 5
+	case WindowLast10:
+		return 10
+	}
+	return 0
+}
+
+// days returns the number of most recent days the window is limited to, 0 if not limited
+func (w Window) days() int {
+	if w == WindowLast30Days {
+		return 30
+	}
+	return 0
+}
+
+// Filter restricts the records an aggregate is computed over
+type Filter struct {
+	Window Window
+	// AsOf restricts the records to the games played and the records stored up to that time, zero for now
+	AsOf time.Time
+}
+
+// ParseAsOf parses a date, meaning the end of that day, or an RFC 3339 timestamp, rounded down to the minute
+// so that the snapshots of a minute share their cached values
+func ParseAsOf(s string) (time.Time, error) {
+	if date, err := time.Parse(time.DateOnly, s); err == nil {
+		return date.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	asOf, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid asOf %q, expected YYYY-MM-DD or RFC 3339 timestamp", s)
+	}
+	return asOf.Truncate(time.Minute), nil
+}
+
+// cacheKeySuffix distinguishes the cached aggregates of different filters.
+// Day-based windows up to now move with the calendar, so their keys include the current date.
+// asOf is a whole minute or the end of a day, so its key needs no more than seconds.
+func (f Filter) cacheKeySuffix() string {
+	var suffix string
+	if f.Window != WindowAll {
+		suffix += "_" + string(f.Window)
+	}
+	if !f.AsOf.IsZero() {
+		suffix += "_asof_" + f.AsOf.UTC().Format(time.RFC3339)
+	} else if f.Window.days() > 0 {
+		suffix += "_" + time.Now().Format(time.DateOnly)
+	}
+	return suffix
+}
+
+// asOfSettled is how long ago asOf must be for its snapshot to be final: records stored in transactions still
+// in flight at asOf, or by pods whose clock is behind the database, may still show up in more recent snapshots
+const asOfSettled = time.Hour
+
+// immutable reports whether the records selected by the filter can no longer change, so their aggregates are cached
+// for immutableCacheTTL instead of the TTL of their kind
+func (f Filter) immutable() bool {
+	return !f.AsOf.IsZero() && time.Since(f.AsOf) > asOfSettled
+}
+
+// isAll reports whether the filter selects all records, so the aggregate can be read from the running totals
+func (f Filter) isAll() bool {
+	return f.Window == WindowAll && f.AsOf.IsZero()
+}
+
+// conditions returns the SQL conditions on the records r selected by the filter, apart from the last games limit.
+// Records stored before game dates were recorded have none, so they only count towards the aggregates of all records.
+func (f Filter) conditions(args *queryArgs) string {
+	var conditions string
+	if !f.isAll() {
+		conditions += " AND r.game_date IS NOT NULL"
+	}
+	end := "CURRENT_DATE"
+	if !f.AsOf.IsZero() {
+		end = args.add(f.AsOf.UTC().Format(time.DateOnly)) + "::date"
+		conditions += fmt.Sprintf(" AND r.game_date <= %s AND r.created_at <= %s::timestamptz", end, args.add(f.AsOf.UTC()))
+	}
+	if days := f.Window.days(); days > 0 {
+		conditions += fmt.Sprintf(" AND r.game_date > %s - %s::int", end, args.add(days))
+	}
+	return conditions
+}
+
+// Aggregates count a game per record of a player, and per date on which any of a team's players has a record
+const (
+	playerGamesCount = "COUNT(r.id)"
+	teamGamesCount   = "COUNT(DISTINCT r.game_date)"
+)
+
+// aggregateColumns returns the aggregate columns of the records r, counting the games with gamesCount
+func aggregateColumns(gamesCount string) string {
+	return gamesCount + ` AS games, COALESCE(AVG(r.points), 0) AS points, COALESCE(AVG(r.rebounds), 0) AS rebounds, COALESCE(AVG(r.assists), 0) AS assists, COALESCE(AVG(r.steals), 0) AS steals, COALESCE(AVG(r.blocks), 0) AS blocks, COALESCE(AVG(r.turnovers), 0) AS turnovers, COALESCE(AVG(r.fouls), 0) AS fouls, COALESCE(AVG(r.minutes), 0) AS minutes, ` +
+		`COUNT(r.id) FILTER (WHERE ` + doubleDigitsCount + ` >= 2) AS double_doubles, COUNT(r.id) FILTER (WHERE ` + doubleDigitsCount + ` >= 3) AS triple_doubles`
+}
+
+// doubleDigitsCount counts the double-double categories of a record reaching 10
+const doubleDigitsCount = `((r.points >= 10)::int + (r.rebounds >= 10)::int + (r.assists >= 10)::int + (r.steals >= 10)::int + (r.blocks >= 10)::int)`
+
+// aggregateFields returns the scan destinations of the aggregate columns
+func aggregateFields(a *AggregatedRecord) []interface{} {
+	return []interface{}{&a.Games, &a.Points, &a.Rebounds, &a.Assists, &a.Steals, &a.Blocks, &a.Turnovers, &a.Fouls, &a.Minutes, &a.DoubleDoubles, &a.TripleDoubles}
+}
+
+// aggregateQuery returns the query averaging the statistics of the records selected by source
+func aggregateQuery(gamesCount, source string, args queryArgs) Query {
+	return Query{fmt.Sprintf(`SELECT %s FROM (%s) r;`, aggregateColumns(gamesCount), source), args}
+}
+
+// totalsQuery returns the query of the aggregate from the running totals kept in table for the given ID.
+// The statistics are averaged per record, as the aggregates of the records are.
+func totalsQuery(table, idColumn string, id int) Query {
+	var averages []string
+	for _, stat := range RecordStats {
+		averages = append(averages, fmt.Sprintf("t.%[1]s::float8 / t.records AS %[1]s", stat))
+	}
+	return Query{fmt.Sprintf(`SELECT t.games, %s, t.double_doubles, t.triple_doubles FROM %s t WHERE t.%s=$1 AND t.records > 0;`, strings.Join(averages, ", "), table, idColumn), []interface{}{id}}
+}
+
+// RebuildTotals recomputes the running totals of all players and teams from their records, e.g. after records
+// were fixed by hand. The records are locked against inserts meanwhile, so no record is counted twice or missed.
+func RebuildTotals(db Database) error {
+	// Records without a game date count as games of their player, not of their team
+	sums := "SUM(r.points), SUM(r.rebounds), SUM(r.assists), SUM(r.steals), SUM(r.blocks), SUM(r.turnovers), SUM(r.fouls), SUM(r.minutes), " +
+		`COUNT(r.id) FILTER (WHERE ` + doubleDigitsCount + ` >= 2), COUNT(r.id) FILTER (WHERE ` + doubleDigitsCount + ` >= 3)`
+	return db.InTx(func(tx common.Tx) error {
+		for _, statement := range []string{
+			"LOCK TABLE records IN SHARE MODE",
+			"DELETE FROM team_totals",
+			"DELETE FROM player_totals",
+			fmt.Sprintf(`INSERT INTO player_totals (player_id, games, records, points, rebounds, assists, steals, blocks, turnovers, fouls, minutes, double_doubles, triple_doubles)
+				SELECT r.player_id, %s, COUNT(r.id), %s FROM records r GROUP BY r.player_id`, playerGamesCount, sums),
+			fmt.Sprintf(`INSERT INTO team_totals (team_id, games, records, points, rebounds, assists, steals, blocks, turnovers, fouls, minutes, double_doubles, triple_doubles)
+				SELECT p.team_id, %s, COUNT(r.id), %s FROM records r JOIN players p ON r.player_id = p.id GROUP BY p.team_id`, teamGamesCount, sums),
+		} {
+			if err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SplitDimensions are the dimensions aggregates can be broken down by: the month of the game, the days of rest before it,
+// and, for the records stored with their game, whether it was played at home, its result and the opponent
+var SplitDimensions = []string{"month", "rest", "home", "result", "opponent"}
+
+// gameSplits are the split columns of the game dimensions, from the side g of the game of the team of the record
+var gameSplits = map[string]string{
+	"home":     "CASE WHEN g.home THEN 'home' ELSE 'away' END",
+	"result":   "CASE g.result WHEN 'W' THEN 'win' WHEN 'L' THEN 'loss' END",
+	"opponent": "(SELECT t.name FROM teams t WHERE t.id = g.opponent_id)",
+}
+
+// splitQuery returns the query averaging the statistics of the records selected by source per split of the dimension
+func splitQuery(dimension, gamesCount, source string, args queryArgs) Query {
+	switch dimension {
+	case "month":
+		return Query{fmt.Sprintf(`SELECT to_char(r.game_date, 'YYYY-MM') AS split, %s FROM (%s) r WHERE r.game_date IS NOT NULL GROUP BY split ORDER BY split;`, aggregateColumns(gamesCount), source), args}
+	case "home", "result", "opponent":
+		// Games still tied have no result yet
+		return Query{fmt.Sprintf(`SELECT %s AS split, %s FROM (%s) r JOIN players p ON p.id = r.player_id JOIN team_games g ON g.game_id = r.game_id AND g.team_id = p.team_id WHERE %[1]s IS NOT NULL GROUP BY split ORDER BY split;`, gameSplits[dimension], aggregateColumns(gamesCount), source), args}
+	case "rest":
+		return Query{fmt.Sprintf(`WITH s AS (%[1]s), g AS (SELECT d.game_date, d.game_date - LAG(d.game_date) OVER (ORDER BY d.game_date) - 1 AS rest FROM (SELECT DISTINCT s.game_date FROM s WHERE s.game_date IS NOT NULL) d) SELECT CASE WHEN g.rest IS NULL THEN 'first' WHEN g.rest >= 3 THEN '3+' ELSE CAST(g.rest AS TEXT) END AS split, %[2]s FROM s r JOIN g ON g.game_date = r.game_date GROUP BY split ORDER BY split;`, source, aggregateColumns(gamesCount)), args}
+	}
+	return Query{}
 }
 
 type AggregatedObject interface {
 	NewAggregatedRecord() *AggregatedRecord
-	CacheKey() string
-	DBQuery() string
+	CacheKey(f Filter) string
+	DBQuery(f Filter) Query
+	// SeriesQuery returns the per game values of a record statistic with their rolling average over the given number of games,
+	// for the records that pass the filter. The statistic is a column name, so it must be one of RecordStats.
+	SeriesQuery(stat string, games int, f Filter) Query
+	SplitQuery(dimension string, f Filter) Query
+	// recordsQuery returns the SQL selecting the records of the object that pass the filter, adding its values to args
+	recordsQuery(f Filter, args *queryArgs) string
 }
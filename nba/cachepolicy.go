@@ -0,0 +1,125 @@
+package nba
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// cacheSchemaVersion prefixes all cache keys. Bump it whenever the shape of a cached value changes,
+// so a deploy never serves the payloads cached by the previous version.
+const cacheSchemaVersion = 1
+
+// versionedKey prefixes the key with the cache schema version
+func versionedKey(key string) string {
+	return fmt.Sprintf("v%d_%s", cacheSchemaVersion, key)
+}
+
+// The kinds of cached values, each with its own TTL.
+// Aggregates back the aggregate, compare, leaders, similar and search endpoints, splits the splits endpoints,
+// fantasy the fantasy endpoints and games the standings and ratings.
+const (
+	CacheAggregate = "aggregate"
+	CacheSplits    = "splits"
+	CacheFantasy   = "fantasy"
+	CacheGames     = "games"
+)
+
+var CacheKinds = []string{CacheAggregate, CacheSplits, CacheFantasy, CacheGames}
+
+// The endpoints serving cached values, by path, each of which can be disabled. A disabled endpoint neither reads
+// nor writes the cached values it shares with other endpoints, which keep caching them.
+const (
+	EndpointPlayerAggregate  = "/aggregate/player"
+	EndpointTeamAggregate    = "/aggregate/team"
+	EndpointPlayerSplits     = "/aggregate/player/splits"
+	EndpointTeamSplits       = "/aggregate/team/splits"
+	EndpointPlayersAggregate = "/aggregate/players"
+	EndpointTeamsAggregate   = "/aggregate/teams"
+	EndpointLeaders          = "/leaders"
+	EndpointCompare          = "/compare"
+	EndpointPlayerFantasy    = "/fantasy/player"
+	EndpointFantasyLeaders   = "/fantasy/leaders"
+	EndpointSimilar          = "/similar"
+	EndpointSearch           = "/players/search"
+	EndpointStandings        = "/standings"
+	// EndpointRatings covers the ratings history and predictions too, which read the ratings
+	EndpointRatings = "/ratings"
+)
+
+var CacheEndpoints = []string{EndpointPlayerAggregate, EndpointTeamAggregate, EndpointPlayerSplits, EndpointTeamSplits, EndpointPlayersAggregate,
+	EndpointTeamsAggregate, EndpointLeaders, EndpointCompare, EndpointPlayerFantasy, EndpointFantasyLeaders, EndpointSimilar, EndpointSearch,
+	EndpointStandings, EndpointRatings}
+
+// DefaultCacheTTLs bound how long a value survives a missed invalidation
+var DefaultCacheTTLs = map[string]time.Duration{
+	CacheAggregate: time.Hour,
+	CacheSplits:    time.Hour,
+	CacheFantasy:   time.Hour,
+	CacheGames:     time.Hour,
+}
+
+// immutableCacheTTL is the TTL of immutable values, such as settled snapshots. They are never invalidated,
+// but any past time can be asked for, so they still expire to bound the number of keys.
+const immutableCacheTTL = 24 * time.Hour
+
+// cacheTTLJitter is the fraction of the TTL expiries are spread over, so keys cached together do not expire together
+const cacheTTLJitter = 0.1
+
+func isCacheKind(kind string) bool {
+	for _, k := range CacheKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCacheTTLs parses a comma separated list of kind=duration pairs, such as aggregate=30m,splits=2h,
+// on top of the default TTLs. A zero duration keeps the values of the kind until they are invalidated.
+func ParseCacheTTLs(s string) (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration)
+	for kind, ttl := range DefaultCacheTTLs {
+		ttls[kind] = ttl
+	}
+	if s == "" {
+		return ttls, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kind, ttlStr, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !isCacheKind(kind) {
+			return nil, fmt.Errorf("invalid cache TTL %q, expected one of %v followed by =duration", pair, CacheKinds)
+		}
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid cache TTL %q", pair)
+		}
+		ttls[kind] = ttl
+	}
+	return ttls, nil
+}
+
+// cacheEnabled reports whether the endpoint reads and writes its values in the cache
+func (nba *NBAStatistics) cacheEnabled(endpoint string) bool {
+	return !nba.options.CacheDisabled[endpoint]
+}
+
+// cacheTTL returns the jittered TTL of a value of the kind, or immutableCacheTTL for immutable values
+func (nba *NBAStatistics) cacheTTL(kind string, immutable bool) time.Duration {
+	ttl, ok := nba.options.CacheTTLs[kind]
+	if !ok {
+		ttl = DefaultCacheTTLs[kind]
+	}
+	if immutable {
+		ttl = immutableCacheTTL
+	}
+	if ttl <= 0 {
+		return 0
+	}
+	jitter := time.Duration(float64(ttl) * cacheTTLJitter)
+	if jitter <= 0 {
+		return ttl
+	}
+	return ttl - jitter + time.Duration(rand.Int63n(int64(2*jitter)+1))
+}
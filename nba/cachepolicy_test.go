@@ -0,0 +1,155 @@
+package nba
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/cache"
+)
+
+func TestParseCacheTTLs(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{s: "", want: DefaultCacheTTLs},
+		{s: "aggregate=30m, splits=0s", want: map[string]time.Duration{CacheAggregate: 30 * time.Minute, CacheSplits: 0, CacheFantasy: DefaultCacheTTLs[CacheFantasy]}},
+		{s: "series=1h", wantErr: true},
+		{s: "aggregate", wantErr: true},
+		{s: "aggregate=-1m", wantErr: true},
+	}
+
+	for _, test := range tests {
+		ttls, err := ParseCacheTTLs(test.s)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseCacheTTLs(%q) error = %v, want error %v", test.s, err, test.wantErr)
+			continue
+		}
+		for kind, ttl := range test.want {
+			if ttls[kind] != ttl {
+				t.Errorf("ParseCacheTTLs(%q)[%s] = %v, want %v", test.s, kind, ttls[kind], ttl)
+			}
+		}
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	nba := &NBAStatistics{options: Options{CacheTTLs: map[string]time.Duration{CacheAggregate: time.Hour, CacheSplits: 0}}}
+
+	for i := 0; i < 100; i++ {
+		if ttl := nba.cacheTTL(CacheAggregate, false); ttl < 54*time.Minute || ttl > 66*time.Minute {
+			t.Fatalf("jittered TTL %v is not within 10%% of 1h", ttl)
+		}
+	}
+	if ttl := nba.cacheTTL(CacheSplits, true); ttl < immutableCacheTTL*9/10 || ttl > immutableCacheTTL*11/10 {
+		t.Errorf("immutable values expire after %v, want about %v", ttl, immutableCacheTTL)
+	}
+	if ttl := nba.cacheTTL(CacheSplits, false); ttl != 0 {
+		t.Errorf("got TTL %v for a kind configured without expiry", ttl)
+	}
+	if ttl := nba.cacheTTL(CacheFantasy, false); ttl < 54*time.Minute || ttl > 66*time.Minute {
+		t.Errorf("got TTL %v for a kind not configured, want the default", ttl)
+	}
+}
+
+func TestOnlySettledSnapshotsAreImmutable(t *testing.T) {
+	for _, test := range []struct {
+		asOf time.Time
+		want bool
+	}{
+		{time.Time{}, false},
+		{time.Now().Add(-time.Minute), false},
+		{time.Now().Add(-asOfSettled - time.Minute), true},
+	} {
+		if got := (Filter{AsOf: test.asOf}).immutable(); got != test.want {
+			t.Errorf("immutable() = %v for asOf %v, want %v", got, test.asOf, test.want)
+		}
+	}
+}
+
+func TestAsOfKeysShareTheMinute(t *testing.T) {
+	first, err := ParseAsOf("2025-02-10T12:30:05.123Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ParseAsOf("2025-02-10T12:30:59Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	player := Player{ID: 23}
+	if key := player.CacheKey(Filter{AsOf: first}); key != player.CacheKey(Filter{AsOf: second}) || key != versionedKey("player_23_asof_2025-02-10T12:30:00Z") {
+		t.Errorf("got keys %q and %q for asOf in the same minute", key, player.CacheKey(Filter{AsOf: second}))
+	}
+}
+
+func TestCacheKeysAreVersioned(t *testing.T) {
+	want := versionedKey("player_23_last5")
+	if key := (Player{ID: 23}).CacheKey(Filter{Window: WindowLast5}); key != want {
+		t.Errorf("got key %q, want %q", key, want)
+	}
+}
+
+func TestStaleValueIsBounded(t *testing.T) {
+	memory := cache.NewMemoryCache(1000)
+	nba := &NBAStatistics{cache: memory, options: Options{StaleWhileRevalidate: true, CacheTTLs: map[string]time.Duration{CacheAggregate: 10 * time.Millisecond, CacheSplits: 0}}}
+
+	if ttl := nba.staleTTL(CacheSplits); ttl != DefaultCacheTTLs[CacheSplits] {
+		t.Errorf("got stale TTL %v for a kind without expiry, want the default TTL", ttl)
+	}
+
+	memory.Set("key", "old", 0)
+	if err := nba.invalidateKey(CacheAggregate, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := memory.Get(staleKey("key")); err != nil || value != "old" {
+		t.Fatalf("got stale value %q, %v after the invalidation", value, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := memory.Get(staleKey("key")); err == nil {
+		t.Error("stale value outlived the TTL of its kind")
+	}
+
+	memory.Set("key", "old", 0)
+	nba.invalidateKey(CacheAggregate, "key")
+	if _, err := nba.fill("key", 0, func() ([]byte, error) { return []byte("new"), nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memory.Get(staleKey("key")); err == nil {
+		t.Error("stale value kept after the key was filled")
+	}
+}
+
+// An endpoint with caching disabled reads the DB while the other endpoints keep serving the values they share
+func TestCacheDisabledPerEndpoint(t *testing.T) {
+	db := newSeededDB()
+	nba, err := NewNBAStatistics(cache.NewMemoryCache(1000), db, Options{CacheDisabled: map[string]bool{EndpointCompare: true, EndpointLeaders: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(nba, (*NBAStatistics).GetPlayerAggregate, "GET", "/aggregate/player?playerId=4", "")
+	serve(nba, (*NBAStatistics).GetLeaders, "GET", "/leaders?stat=points", "")
+
+	// A record the cache is not told about
+	db.addRecord(4, "2025-02-03", statLine(28, 2, 2, 0, 0, 1, 2, 32))
+	db.backfillTotals("player_totals")
+
+	for _, test := range []struct {
+		handler func(*NBAStatistics, http.ResponseWriter, *http.Request)
+		target  string
+		want    string
+	}{
+		{(*NBAStatistics).GetPlayerAggregate, "/aggregate/player?playerId=4", `"games":1,`},
+		{(*NBAStatistics).Compare, "/compare?players=4", `"games":2,`},
+		{(*NBAStatistics).GetLeaders, "/leaders?stat=points&minGames=2&minMinutes=0", `"id":4`},
+	} {
+		if w := serve(nba, test.handler, "GET", test.target, ""); !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s: expected %s: %s", test.target, test.want, w.Body.String())
+		}
+	}
+	if n, _ := nba.cache.ZCard(leadersCacheKey("players", "points")); n != 0 {
+		t.Errorf("leaderboards built for the leaders endpoint with caching disabled")
+	}
+}
@@ -0,0 +1,200 @@
+package nba
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CompareResult holds the aggregates of the compared players or teams, in the listed order,
+// and for teams the results of the games between them
+type CompareResult struct {
+	Records    []AggregatedRecord `json:"records"`
+	HeadToHead *HeadToHead        `json:"headToHead,omitempty"`
+}
+
+// HeadToHead holds the games between the compared teams, newest first, and the wins and losses of each team in them
+type HeadToHead struct {
+	Games   []HeadToHeadGame   `json:"games"`
+	Records []HeadToHeadRecord `json:"records"`
+}
+
+type HeadToHeadGame struct {
+	ID         int    `json:"id"`
+	Date       string `json:"date"`
+	HomeTeamID int    `json:"homeTeamId"`
+	HomePoints int    `json:"homePoints"`
+	AwayTeamID int    `json:"awayTeamId"`
+	AwayPoints int    `json:"awayPoints"`
+	// WinnerID is the ID of the team with more points, 0 while they are tied
+	WinnerID int `json:"winnerId,omitempty"`
+}
+
+type HeadToHeadRecord struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Wins   int    `json:"wins"`
+	Losses int    `json:"losses"`
+}
+
+// parseIDs parses a comma separated list of IDs
+func parseIDs(s string) ([]int, error) {
+	var ids []int
+	for _, idStr := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(idStr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID %q", idStr)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// compareObjects returns the players or teams listed in the request, in the listed order
+func (nba *NBAStatistics) compareObjects(r *http.Request) ([]AggregatedObject, error) {
+	playersStr, teamsStr := r.URL.Query().Get("players"), r.URL.Query().Get("teams")
+	if (playersStr == "") == (teamsStr == "") {
+		return nil, fmt.Errorf("either players or teams must be given")
+	}
+
+	var objects []AggregatedObject
+	if playersStr != "" {
+		ids, err := parseIDs(playersStr)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			player, exists := nba.players[id]
+			if !exists {
+				return nil, fmt.Errorf("player with ID %d does not exist", id)
+			}
+			objects = append(objects, player)
+		}
+	} else {
+		ids, err := parseIDs(teamsStr)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			team, exists := nba.teams[id]
+			if !exists {
+				return nil, fmt.Errorf("team with ID %d does not exist", id)
+			}
+			objects = append(objects, team)
+		}
+	}
+	return objects, nil
+}
+
+// headToHeadQuery returns the query of the games between the teams, newest first, once per game from the side of the
+// home team. The filter restricts them by game date and the last games window to the last games between the teams.
+func headToHeadQuery(teamIDs []int, f Filter) Query {
+	var args queryArgs
+	var placeholders []string
+	for _, id := range teamIDs {
+		placeholders = append(placeholders, args.add(id))
+	}
+	teams := strings.Join(placeholders, ", ")
+	sql := fmt.Sprintf(`SELECT g.game_id, g.game_date, g.team_id, g.points, g.opponent_id, g.opponent_points FROM team_games g WHERE g.home AND g.team_id IN (%[1]s) AND g.opponent_id IN (%[1]s)`, teams)
+	end := "CURRENT_DATE"
+	if !f.AsOf.IsZero() {
+		end = args.add(f.AsOf.UTC().Format(time.DateOnly)) + "::date"
+		sql += " AND g.game_date <= " + end
+	}
+	if days := f.Window.days(); days > 0 {
+		sql += fmt.Sprintf(" AND g.game_date > %s - %s::int", end, args.add(days))
+	}
+	sql += " ORDER BY g.game_date DESC, g.game_id DESC"
+	if games := f.Window.lastGames(); games > 0 {
+		sql += " LIMIT " + args.add(games)
+	}
+	return Query{sql + ";", args}
+}
+
+// getHeadToHead returns the games between the teams that pass the filter and the record of each team in them.
+// The games are read from the DB, as the result of a game changes with every record of either team.
+func (nba *NBAStatistics) getHeadToHead(teams []Team, f Filter) (*HeadToHead, error) {
+	var ids []int
+	for _, team := range teams {
+		ids = append(ids, team.ID)
+	}
+	query := headToHeadQuery(ids, f)
+	rows, err := nba.db.Query(query.SQL, query.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the games between teams %v", ids)
+	}
+	defer rows.Close()
+
+	headToHead := &HeadToHead{Games: []HeadToHeadGame{}}
+	wins, losses := make(map[int]int), make(map[int]int)
+	for rows.Next() {
+		var (
+			game     HeadToHeadGame
+			gameDate time.Time
+		)
+		if err := rows.Scan(&game.ID, &gameDate, &game.HomeTeamID, &game.HomePoints, &game.AwayTeamID, &game.AwayPoints); err != nil {
+			return nil, err
+		}
+		game.Date = gameDate.Format(time.DateOnly)
+		if game.HomePoints != game.AwayPoints {
+			winner, loser := game.HomeTeamID, game.AwayTeamID
+			if game.AwayPoints > game.HomePoints {
+				winner, loser = loser, winner
+			}
+			game.WinnerID = winner
+			wins[winner]++
+			losses[loser]++
+		}
+		headToHead.Games = append(headToHead.Games, game)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, team := range teams {
+		headToHead.Records = append(headToHead.Records, HeadToHeadRecord{ID: team.ID, Name: team.Name, Wins: wins[team.ID], Losses: losses[team.ID]})
+	}
+	return headToHead, nil
+}
+
+func (nba *NBAStatistics) Compare(w http.ResponseWriter, r *http.Request) {
+	objects, err := nba.compareObjects(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := CompareResult{Records: []AggregatedRecord{}}
+	var teams []Team
+	for _, object := range objects {
+		record, err := nba.getAggregateRecord(EndpointCompare, object, f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result.Records = append(result.Records, *record)
+		if team, ok := object.(Team); ok {
+			teams = append(teams, team)
+		}
+	}
+
+	if len(teams) > 0 {
+		if result.HeadToHead, err = nba.getHeadToHead(teams, f); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	resultJSON, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultJSON)
+}
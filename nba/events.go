@@ -0,0 +1,312 @@
+package nba
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/common"
+)
+
+const (
+	EventDoubleDouble = "double_double"
+	EventTripleDouble = "triple_double"
+	EventFortyPoints  = "40_points"
+
+	// Career high events are named career_high_<stat>, cumulative milestones career_<stat>
+	eventCareerHighPrefix = "career_high_"
+	eventCareerPrefix     = "career_"
+
+	defaultEventsLimit = 50
+)
+
+// doubleDigitsStats are the statistics counted towards double-doubles and triple-doubles
+var doubleDigitsStats = []string{"points", "rebounds", "assists", "steals", "blocks"}
+
+// milestoneStats are tracked for career highs and reach a cumulative milestone at every multiple of their step
+var (
+	milestoneStats = []string{"points", "rebounds", "assists"}
+	milestoneSteps = map[string]int{"points": 5000, "rebounds": 2500, "assists": 2500}
+)
+
+type Event struct {
+	ID       int    `json:"id"`
+	PlayerID int    `json:"playerId"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Value    int    `json:"value"`
+	Date     string `json:"date"`
+}
+
+// career holds the totals and highs of a player over the records preceding the evaluated one
+type career struct {
+	games  int
+	highs  map[string]int
+	totals map[string]int
+}
+
+func newCareer() *career {
+	return &career{highs: make(map[string]int), totals: make(map[string]int)}
+}
+
+func (c *career) add(record *Record) {
+	c.games++
+	for _, stat := range milestoneStats {
+		value := record.stat(stat)
+		if value > c.highs[stat] {
+			c.highs[stat] = value
+		}
+		c.totals[stat] += value
+	}
+}
+
+// detectEvents returns the events achieved by the record on top of the player's previous career
+func detectEvents(record *Record, previous *career) []Event {
+	var events []Event
+	newEvent := func(eventType string, value int) {
+		events = append(events, Event{PlayerID: record.ID, Type: eventType, Value: value, Date: record.Date})
+	}
+
+	doubleDigits := record.doubleDigits()
+	if doubleDigits >= 2 {
+		newEvent(EventDoubleDouble, doubleDigits)
+	}
+	if doubleDigits >= 3 {
+		newEvent(EventTripleDouble, doubleDigits)
+	}
+	if record.Points >= 40 {
+		newEvent(EventFortyPoints, record.Points)
+	}
+
+	for _, stat := range milestoneStats {
+		value := record.stat(stat)
+		if previous.games > 0 && value > previous.highs[stat] {
+			newEvent(eventCareerHighPrefix+stat, value)
+		}
+		step := milestoneSteps[stat]
+		for milestone := (previous.totals[stat]/step + 1) * step; milestone <= previous.totals[stat]+value; milestone += step {
+			newEvent(eventCareerPrefix+stat, milestone)
+		}
+	}
+	return events
+}
+
+// getCareer returns the player's career over the records preceding the given one by game date.
+// Records without a game date cannot be ordered, so they are not part of careers.
+func getCareer(db common.Tx, record *Record) (*career, error) {
+	columns := []string{"COUNT(id)"}
+	for _, stat := range milestoneStats {
+		columns = append(columns, fmt.Sprintf("COALESCE(MAX(%[1]s), 0), COALESCE(SUM(%[1]s), 0)", stat))
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM records WHERE player_id=$1 AND (game_date, id) < ($2::date, $3)", strings.Join(columns, ", ")),
+		record.ID, record.Date, record.recordID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	previous := newCareer()
+	if rows.Next() {
+		highs, totals := make([]int, len(milestoneStats)), make([]int, len(milestoneStats))
+		dest := []interface{}{&previous.games}
+		for i := range milestoneStats {
+			dest = append(dest, &highs[i], &totals[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i, stat := range milestoneStats {
+			previous.highs[stat], previous.totals[stat] = highs[i], totals[i]
+		}
+	}
+	return previous, rows.Err()
+}
+
+func saveEvent(db common.Tx, recordID int, event Event) error {
+	return db.Exec("INSERT INTO events (player_id, record_id, type, value, game_date) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING",
+		event.PlayerID, recordID, event.Type, event.Value, event.Date)
+}
+
+// recordColumns are the columns of a stored record read by scanRecord
+const recordColumns = "id, player_id, points, rebounds, assists, steals, blocks, turnovers, fouls, minutes, game_date"
+
+func scanRecord(rows common.Rows) (Record, error) {
+	var (
+		record   Record
+		gameDate time.Time
+	)
+	if err := rows.Scan(&record.recordID, &record.ID, &record.Points, &record.Rebounds, &record.Assists, &record.Steals, &record.Blocks,
+		&record.Turnovers, &record.Fouls, &record.Minutes, &gameDate); err != nil {
+		return record, err
+	}
+	record.Date = gameDate.Format(time.DateOnly)
+	return record, nil
+}
+
+// getLaterRecords returns the player's records following the given one by game date
+func getLaterRecords(db common.Tx, record *Record) ([]Record, error) {
+	rows, err := db.Query("SELECT "+recordColumns+" FROM records WHERE player_id=$1 AND (game_date, id) > ($2::date, $3) ORDER BY game_date, id",
+		record.ID, record.Date, record.recordID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		later, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, later)
+	}
+	return records, rows.Err()
+}
+
+// recordEvents detects and stores the events of a newly inserted record, in the transaction inserting it.
+// A record dated before other records of the player changes the careers they were evaluated on,
+// so the career events of the later records are detected again.
+func recordEvents(db common.Tx, record *Record) error {
+	previous, err := getCareer(db, record)
+	if err != nil {
+		return err
+	}
+	for _, event := range detectEvents(record, previous) {
+		if err := saveEvent(db, record.recordID, event); err != nil {
+			return err
+		}
+	}
+
+	laterRecords, err := getLaterRecords(db, record)
+	if err != nil || len(laterRecords) == 0 {
+		return err
+	}
+	if err := db.Exec(`DELETE FROM events WHERE player_id=$1 AND type LIKE 'career\_%' ESCAPE '\' AND (game_date, record_id) > ($2::date, $3)`,
+		record.ID, record.Date, record.recordID); err != nil {
+		return err
+	}
+	previous.add(record)
+	for i := range laterRecords {
+		for _, event := range detectEvents(&laterRecords[i], previous) {
+			if !strings.HasPrefix(event.Type, eventCareerPrefix) {
+				continue
+			}
+			if err := saveEvent(db, laterRecords[i].recordID, event); err != nil {
+				return err
+			}
+		}
+		previous.add(&laterRecords[i])
+	}
+	return nil
+}
+
+// BackfillEvents detects the events of all stored records with a game date, in game date order and returns the number of events found.
+// Events that are already stored are kept as is, so the backfill can be repeated.
+func BackfillEvents(db Database) (int, error) {
+	rows, err := db.Query("SELECT " + recordColumns + " FROM records WHERE game_date IS NOT NULL ORDER BY player_id, game_date, id")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var (
+		careers   = make(map[int]*career)
+		recordIDs []int
+		events    []Event
+	)
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return 0, err
+		}
+
+		previous, ok := careers[record.ID]
+		if !ok {
+			previous = newCareer()
+			careers[record.ID] = previous
+		}
+		for _, event := range detectEvents(&record, previous) {
+			recordIDs = append(recordIDs, record.recordID)
+			events = append(events, event)
+		}
+		previous.add(&record)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	for i, event := range events {
+		if err := saveEvent(db, recordIDs[i], event); err != nil {
+			return 0, err
+		}
+	}
+	return len(events), nil
+}
+
+func (nba *NBAStatistics) GetEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	conditions, args := []string{"TRUE"}, []interface{}{}
+	if playerIDStr := query.Get("playerId"); playerIDStr != "" {
+		playerID, err := strconv.Atoi(playerIDStr)
+		if err != nil {
+			http.Error(w, "Invalid playerId", http.StatusBadRequest)
+			return
+		}
+		if _, exists := nba.players[playerID]; !exists {
+			http.Error(w, fmt.Sprintf("player with ID %d does not exist", playerID), http.StatusBadRequest)
+			return
+		}
+		args = append(args, playerID)
+		conditions = append(conditions, fmt.Sprintf("e.player_id=$%d", len(args)))
+	}
+	if eventType := query.Get("type"); eventType != "" {
+		args = append(args, eventType)
+		conditions = append(conditions, fmt.Sprintf("e.type=$%d", len(args)))
+	}
+
+	limit := defaultEventsLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	args = append(args, limit)
+
+	rows, err := nba.db.Query(fmt.Sprintf("SELECT e.id, e.player_id, e.type, e.value, e.game_date FROM events e WHERE %s ORDER BY e.game_date DESC, e.id DESC LIMIT $%d",
+		strings.Join(conditions, " AND "), len(args)), args...)
+	if err != nil {
+		http.Error(w, "cannot get events", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var (
+			event    Event
+			gameDate time.Time
+		)
+		if err := rows.Scan(&event.ID, &event.PlayerID, &event.Type, &event.Value, &gameDate); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		event.Name = nba.players[event.PlayerID].Name
+		event.Date = gameDate.Format(time.DateOnly)
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resultJSON, _ := json.Marshal(events)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultJSON)
+}
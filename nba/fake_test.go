@@ -0,0 +1,973 @@
+package nba
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/common"
+)
+
+// fakeRecord is a stored record; its statistics are keyed by RecordStats.
+// A zero date is a NULL game date, of a record stored before game dates were recorded.
+type fakeRecord struct {
+	id        int
+	playerID  int
+	stats     map[string]float64
+	date      time.Time
+	createdAt time.Time
+	// gameID is the ID of the game of the record, 0 if it was stored without its opponent
+	gameID int
+}
+
+func (r fakeRecord) doubleDigits() int {
+	count := 0
+	for _, stat := range doubleDigitsStats {
+		if r.stats[stat] >= 10 {
+			count++
+		}
+	}
+	return count
+}
+
+type fakeGame struct {
+	id                     int
+	date                   time.Time
+	homeTeamID, awayTeamID int
+}
+
+// fakeRating is a row of team_ratings
+type fakeRating struct {
+	gameID, teamID, opponentID int
+	date                       string
+	ratingBefore, rating       float64
+}
+
+type fakePlayer struct {
+	id     int
+	name   string
+	teamID int
+}
+
+type fakeEvent struct {
+	Event
+	recordID int
+}
+
+// fakeTotals is a row of player_totals or team_totals, summing the statistics of the records
+type fakeTotals struct {
+	games, records               int
+	sums                         map[string]float64
+	doubleDoubles, tripleDoubles int
+}
+
+func (t fakeTotals) add(stats map[string]float64, games, doubleDoubles, tripleDoubles int) fakeTotals {
+	sums := make(map[string]float64)
+	for _, stat := range RecordStats {
+		sums[stat] = t.sums[stat] + stats[stat]
+	}
+	return fakeTotals{games: t.games + games, records: t.records + 1, sums: sums, doubleDoubles: t.doubleDoubles + doubleDoubles, tripleDoubles: t.tripleDoubles + tripleDoubles}
+}
+
+// fakeDB is an in-memory Database interpreting the statements issued by this package.
+// Statements it does not recognize fail, so new queries have to be taught to it.
+type fakeDB struct {
+	mu       sync.Mutex
+	teams    []Team
+	players  []fakePlayer
+	profiles []FantasyProfile
+	records  []fakeRecord
+	events   []fakeEvent
+	games    []fakeGame
+	ratings  []fakeRating
+	// totals are the rows of player_totals and team_totals by ID
+	totals    map[string]map[int]fakeTotals
+	refreshes map[string]time.Time
+	// viewsLocked tells that another instance holds the lock refreshing the views
+	viewsLocked bool
+	fail        string
+	statements  []string
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{refreshes: make(map[string]time.Time), totals: map[string]map[int]fakeTotals{"player_totals": {}, "team_totals": {}}}
+}
+
+// backfillTotals computes the rows of the totals table from the records, as the migration creating it does
+func (db *fakeDB) backfillTotals(table string) {
+	team := table == "team_totals"
+	records := append([]fakeRecord{}, db.records...)
+	sortRecords(records)
+	totals := make(map[int]fakeTotals)
+	lastDates := make(map[int]time.Time)
+	for _, record := range records {
+		id := record.playerID
+		if team {
+			id = db.teamOf(id)
+		}
+		games := 1
+		if lastDate, ok := lastDates[id]; team && (ok && lastDate.Equal(record.date) || record.date.IsZero()) {
+			games = 0
+		}
+		lastDates[id] = record.date
+		var doubleDoubles, tripleDoubles int
+		if record.doubleDigits() >= 2 {
+			doubleDoubles = 1
+		}
+		if record.doubleDigits() >= 3 {
+			tripleDoubles = 1
+		}
+		totals[id] = totals[id].add(record.stats, games, doubleDoubles, tripleDoubles)
+	}
+	db.totals[table] = totals
+}
+
+func (db *fakeDB) addRecord(playerID int, date string, stats map[string]float64) {
+	gameDate, _ := time.Parse(time.DateOnly, date)
+	db.records = append(db.records, fakeRecord{id: len(db.records) + 1, playerID: playerID, stats: stats, date: gameDate, createdAt: gameDate.Add(12 * time.Hour)})
+}
+
+func (db *fakeDB) teamOf(playerID int) int {
+	for _, player := range db.players {
+		if player.id == playerID {
+			return player.teamID
+		}
+	}
+	return 0
+}
+
+func (db *fakeDB) Exec(query string, args ...interface{}) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.exec(query, args)
+}
+
+func (db *fakeDB) Query(query string, args ...interface{}) (common.Rows, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	rows, err := db.query(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: rows, index: -1}, nil
+}
+
+// InTx runs fn against the fake itself, restoring the stored records, games, events, ratings and totals if fn fails
+func (db *fakeDB) InTx(fn func(tx common.Tx) error) error {
+	db.mu.Lock()
+	records, games, events, ratings := len(db.records), len(db.games), append([]fakeEvent{}, db.events...), db.ratings
+	totals := make(map[string]map[int]fakeTotals)
+	for table, rows := range db.totals {
+		totals[table] = make(map[int]fakeTotals)
+		for id, row := range rows {
+			totals[table][id] = row
+		}
+	}
+	db.mu.Unlock()
+	if err := fn(db); err != nil {
+		db.mu.Lock()
+		db.records, db.games, db.events, db.ratings, db.totals = db.records[:records], db.games[:games], events, ratings, totals
+		db.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (db *fakeDB) Close() {}
+
+func (db *fakeDB) check(query string) error {
+	db.statements = append(db.statements, query)
+	if db.fail != "" && strings.Contains(query, db.fail) {
+		return fmt.Errorf("fake: statement failed: %s", query)
+	}
+	return nil
+}
+
+func (db *fakeDB) exec(query string, args []interface{}) error {
+	if err := db.check(query); err != nil {
+		return err
+	}
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO player_totals"), strings.HasPrefix(query, "INSERT INTO team_totals"):
+		table := strings.Fields(query)[2]
+		if !strings.Contains(query, "VALUES") {
+			db.backfillTotals(table)
+			return nil
+		}
+		// The upsert of a record, whose arguments are the ID, RecordStats, the double and triple doubles and the games
+		stats := make(map[string]float64)
+		for i, stat := range RecordStats {
+			stats[stat] = toFloat(args[i+1])
+		}
+		id, n := args[0].(int), len(RecordStats)
+		db.totals[table][id] = db.totals[table][id].add(stats, args[n+3].(int), args[n+1].(int), args[n+2].(int))
+		return nil
+	case strings.HasPrefix(query, "DELETE FROM player_totals"), strings.HasPrefix(query, "DELETE FROM team_totals"):
+		db.totals[strings.Fields(query)[2]] = make(map[int]fakeTotals)
+		return nil
+	case strings.HasPrefix(query, "LOCK TABLE records"), strings.HasPrefix(query, "SELECT pg_advisory_xact_lock"):
+		return nil
+	case strings.HasPrefix(query, "INSERT INTO events"):
+		id := 1
+		if len(db.events) > 0 {
+			id = db.events[len(db.events)-1].ID + 1
+		}
+		event := fakeEvent{Event: Event{ID: id, PlayerID: args[0].(int), Type: args[2].(string), Value: args[3].(int), Date: args[4].(string)}, recordID: args[1].(int)}
+		for _, e := range db.events {
+			if e.recordID == event.recordID && e.Type == event.Type && e.Value == event.Value {
+				return nil
+			}
+		}
+		db.events = append(db.events, event)
+		return nil
+	case strings.HasPrefix(query, "DELETE FROM events"):
+		// The career events of the player's records following the given date and record
+		date, _ := time.Parse(time.DateOnly, args[1].(string))
+		var events []fakeEvent
+		for _, event := range db.events {
+			eventDate, _ := time.Parse(time.DateOnly, event.Date)
+			later := eventDate.After(date) || eventDate.Equal(date) && event.recordID > args[2].(int)
+			if event.PlayerID != args[0].(int) || !later || !strings.HasPrefix(event.Type, eventCareerPrefix) {
+				events = append(events, event)
+			}
+		}
+		db.events = events
+		return nil
+	case strings.HasPrefix(query, "UPDATE records SET game_id"):
+		for i := range db.records {
+			if db.records[i].id == args[1].(int) {
+				db.records[i].gameID = args[0].(int)
+			}
+		}
+		return nil
+	case query == "DELETE FROM team_ratings":
+		db.ratings = nil
+		return nil
+	case strings.HasPrefix(query, "INSERT INTO team_ratings"):
+		db.ratings = append(db.ratings, fakeRating{gameID: args[0].(int), teamID: args[1].(int), date: args[2].(string), opponentID: args[3].(int),
+			ratingBefore: args[4].(float64), rating: args[5].(float64)})
+		return nil
+	case strings.HasPrefix(query, "REFRESH MATERIALIZED VIEW"):
+		return nil
+	case strings.HasPrefix(query, "INSERT INTO aggregate_view_refreshes"):
+		db.refreshes[args[0].(string)] = time.Now()
+		return nil
+	}
+	return fmt.Errorf("fake: unsupported statement: %s", query)
+}
+
+func (db *fakeDB) query(query string, args []interface{}) ([][]interface{}, error) {
+	if err := db.check(query); err != nil {
+		return nil, err
+	}
+	switch {
+	case query == "SELECT id, name, COALESCE(conference, ''), COALESCE(division, '') FROM teams":
+		var rows [][]interface{}
+		for _, team := range db.teams {
+			rows = append(rows, []interface{}{team.ID, team.Name, team.Conference, team.Division})
+		}
+		return rows, nil
+	case query == "SELECT id, name, team_id FROM players":
+		var rows [][]interface{}
+		for _, player := range db.players {
+			rows = append(rows, []interface{}{player.id, player.name, player.teamID})
+		}
+		return rows, nil
+	case strings.Contains(query, "FROM fantasy_profiles"):
+		var rows [][]interface{}
+		for _, profile := range db.profiles {
+			row := []interface{}{profile.Name}
+			for _, stat := range RecordStats {
+				row = append(row, profile.Weights[stat])
+			}
+			rows = append(rows, append(row, profile.DoubleDoubleBonus, profile.TripleDoubleBonus))
+		}
+		return rows, nil
+	case strings.HasPrefix(query, "INSERT INTO records"):
+		return db.insertRecord(args), nil
+	case strings.Contains(query, "FROM player_totals t"), strings.Contains(query, "FROM team_totals t"):
+		return db.queryTotals(query, args), nil
+	case strings.HasPrefix(query, "SELECT pg_try_advisory_xact_lock"):
+		return [][]interface{}{{!db.viewsLocked}}, nil
+	case strings.Contains(query, "FROM aggregate_view_refreshes"):
+		refreshedAt, ok := db.refreshes[args[0].(string)]
+		if !ok {
+			return nil, nil
+		}
+		return [][]interface{}{{refreshedAt, time.Now()}}, nil
+	case strings.Contains(query, "FROM player_aggregates"), strings.Contains(query, "FROM team_aggregates"):
+		return db.viewAggregates(strings.Contains(query, "team_aggregates")), nil
+	case strings.HasPrefix(query, "SELECT COUNT(*) FROM games"):
+		return db.conflictingGames(args), nil
+	case strings.HasPrefix(query, "INSERT INTO games"):
+		return db.insertGame(args), nil
+	case query == ratingsGamesQuery:
+		var rows [][]interface{}
+		for _, game := range db.decidedGames() {
+			rows = append(rows, []interface{}{game.id, game.date, game.homeTeamID, db.teamPoints(game.id, game.homeTeamID), game.awayTeamID, db.teamPoints(game.id, game.awayTeamID)})
+		}
+		return rows, nil
+	case strings.Contains(query, "FROM team_ratings r WHERE r.team_id=$1"):
+		var rows [][]interface{}
+		for _, rating := range db.ratings {
+			if rating.teamID == args[0].(int) {
+				date, _ := time.Parse(time.DateOnly, rating.date)
+				rows = append(rows, []interface{}{rating.gameID, date, rating.opponentID, rating.ratingBefore, rating.rating})
+			}
+		}
+		return rows, nil
+	case query == standingsQuery:
+		return db.gameResults(), nil
+	case strings.Contains(query, "FROM team_games g WHERE g.home"):
+		return db.headToHead(query, args), nil
+	case strings.Contains(query, "AS split"):
+		return db.splits(query, args), nil
+	case strings.Contains(query, "AS average"):
+		return db.series(query, args), nil
+	case strings.Contains(query, "GROUP BY r.player_id"), strings.Contains(query, "GROUP BY p.team_id"):
+		return db.fantasyLeaders(query, args), nil
+	case strings.HasPrefix(query, "SELECT COUNT(r.id), COALESCE(AVG((r.points *"):
+		return db.fantasy(query, args), nil
+	case strings.HasPrefix(query, "SELECT COUNT(r.id) AS games"), strings.HasPrefix(query, "SELECT COUNT(DISTINCT r.game_date) AS games"):
+		return [][]interface{}{aggregateRow(db.selectRecords(query, args), strings.Contains(query, teamGamesCount))}, nil
+	case strings.HasPrefix(query, "SELECT COUNT(*) FROM records r JOIN players p"):
+		return db.teamRecordsOn(args), nil
+	case strings.Contains(query, "COALESCE(MAX(points), 0)"):
+		return db.career(args), nil
+	case strings.Contains(query, "FROM events e"):
+		return db.queryEvents(query, args), nil
+	case strings.Contains(query, "::float8 FROM records r WHERE r.player_id=$1"):
+		return db.recentRecords(query, args), nil
+	case strings.Contains(query, "FROM records WHERE game_date IS NOT NULL ORDER BY player_id, game_date, id"):
+		return db.allRecords(func(record fakeRecord) bool {
+			return !record.date.IsZero()
+		}), nil
+	case strings.Contains(query, "FROM records WHERE player_id=$1 AND (game_date, id) >"):
+		date, _ := time.Parse(time.DateOnly, args[1].(string))
+		return db.allRecords(func(record fakeRecord) bool {
+			return record.playerID == args[0].(int) && (record.date.After(date) || record.date.Equal(date) && record.id > args[2].(int))
+		}), nil
+	}
+	return nil, fmt.Errorf("fake: unsupported query: %s", query)
+}
+
+func (db *fakeDB) insertRecord(args []interface{}) [][]interface{} {
+	record := fakeRecord{id: len(db.records) + 1, playerID: args[0].(int), stats: make(map[string]float64), createdAt: time.Now()}
+	for i, stat := range RecordStats {
+		record.stats[stat] = toFloat(args[i+1])
+	}
+	record.date = time.Now().Truncate(24 * time.Hour)
+	if date, ok := args[len(RecordStats)+1].(string); ok {
+		record.date, _ = time.Parse(time.DateOnly, date)
+	}
+	db.records = append(db.records, record)
+	return [][]interface{}{{record.id, record.date}}
+}
+
+// addGame stores a game and links the records of both teams on its date to it
+func (db *fakeDB) addGame(date string, homeTeamID, awayTeamID int) {
+	gameDate, _ := time.Parse(time.DateOnly, date)
+	game := fakeGame{id: len(db.games) + 1, date: gameDate, homeTeamID: homeTeamID, awayTeamID: awayTeamID}
+	db.games = append(db.games, game)
+	for i, record := range db.records {
+		if team := db.teamOf(record.playerID); record.date.Equal(gameDate) && (team == homeTeamID || team == awayTeamID) {
+			db.records[i].gameID = game.id
+		}
+	}
+}
+
+// conflictingGames counts the games on the date of either team other than the game of the home and away teams given
+func (db *fakeDB) conflictingGames(args []interface{}) [][]interface{} {
+	date, _ := time.Parse(time.DateOnly, args[0].(string))
+	home, away := args[1].(int), args[2].(int)
+	count := 0
+	for _, game := range db.games {
+		teams := []int{game.homeTeamID, game.awayTeamID}
+		involved := slices.Contains(teams, home) || slices.Contains(teams, away)
+		if game.date.Equal(date) && involved && !(game.homeTeamID == home && game.awayTeamID == away) {
+			count++
+		}
+	}
+	return [][]interface{}{{count}}
+}
+
+func (db *fakeDB) insertGame(args []interface{}) [][]interface{} {
+	date, _ := time.Parse(time.DateOnly, args[0].(string))
+	for _, game := range db.games {
+		if game.date.Equal(date) && game.homeTeamID == args[1].(int) && game.awayTeamID == args[2].(int) {
+			return [][]interface{}{{game.id}}
+		}
+	}
+	game := fakeGame{id: len(db.games) + 1, date: date, homeTeamID: args[1].(int), awayTeamID: args[2].(int)}
+	db.games = append(db.games, game)
+	return [][]interface{}{{game.id}}
+}
+
+// teamGame returns the side of the team in the game, like a row of the team_games view,
+// with its result W or L, empty while the points are tied
+func (db *fakeDB) teamGame(gameID, teamID int) (home bool, opponentID int, result string) {
+	for _, game := range db.games {
+		if game.id != gameID {
+			continue
+		}
+		home, opponentID = game.homeTeamID == teamID, game.homeTeamID
+		if home {
+			opponentID = game.awayTeamID
+		}
+		points, opponentPoints := db.teamPoints(gameID, teamID), db.teamPoints(gameID, opponentID)
+		if points > opponentPoints {
+			result = "W"
+		} else if points < opponentPoints {
+			result = "L"
+		}
+		return home, opponentID, result
+	}
+	return false, 0, ""
+}
+
+var (
+	gameTeamsPattern = regexp.MustCompile(`g\.team_id IN \(([^)]*)\)`)
+	gameEndPattern   = regexp.MustCompile(`g\.game_date <= \$(\d+)::date`)
+	gameDaysPattern  = regexp.MustCompile(`g\.game_date > (?:CURRENT_DATE|\$\d+::date) - \$(\d+)::int`)
+)
+
+// headToHead interprets headToHeadQuery, returning the games between the teams from the side of the home team, newest first
+func (db *fakeDB) headToHead(query string, args []interface{}) [][]interface{} {
+	var teams []int
+	for _, match := range placeholderPattern.FindAllStringSubmatch(gameTeamsPattern.FindStringSubmatch(query)[1], -1) {
+		n, _ := strconv.Atoi(match[1])
+		teams = append(teams, args[n-1].(int))
+	}
+	end := time.Now().Truncate(24 * time.Hour)
+	if date, ok := arg(gameEndPattern, query, args); ok {
+		end, _ = time.Parse(time.DateOnly, date.(string))
+	}
+	days, byDays := arg(gameDaysPattern, query, args)
+
+	games := append([]fakeGame{}, db.games...)
+	sort.Slice(games, func(i, j int) bool {
+		if !games[i].date.Equal(games[j].date) {
+			return games[i].date.After(games[j].date)
+		}
+		return games[i].id > games[j].id
+	})
+	var rows [][]interface{}
+	for _, game := range games {
+		if !slices.Contains(teams, game.homeTeamID) || !slices.Contains(teams, game.awayTeamID) || game.date.After(end) || byDays && !game.date.After(end.AddDate(0, 0, -days.(int))) {
+			continue
+		}
+		rows = append(rows, []interface{}{game.id, game.date, game.homeTeamID, db.teamPoints(game.id, game.homeTeamID), game.awayTeamID, db.teamPoints(game.id, game.awayTeamID)})
+	}
+	if limit, ok := arg(limitPattern, query, args); ok {
+		rows = rows[:min(len(rows), limit.(int))]
+	}
+	return rows
+}
+
+// decidedGames returns the games that are not tied in game order
+func (db *fakeDB) decidedGames() []fakeGame {
+	var games []fakeGame
+	for _, game := range db.games {
+		if _, _, result := db.teamGame(game.id, game.homeTeamID); result != "" {
+			games = append(games, game)
+		}
+	}
+	sort.Slice(games, func(i, j int) bool {
+		if !games[i].date.Equal(games[j].date) {
+			return games[i].date.Before(games[j].date)
+		}
+		return games[i].id < games[j].id
+	})
+	return games
+}
+
+// gameResults returns the results of the games from the side of each team in game order, see standingsQuery
+func (db *fakeDB) gameResults() [][]interface{} {
+	var rows [][]interface{}
+	for _, game := range db.decidedGames() {
+		for _, teamID := range []int{game.homeTeamID, game.awayTeamID} {
+			home, _, result := db.teamGame(game.id, teamID)
+			rows = append(rows, []interface{}{teamID, home, result})
+		}
+	}
+	return rows
+}
+
+func (db *fakeDB) teamPoints(gameID, teamID int) float64 {
+	var points float64
+	for _, record := range db.records {
+		if record.gameID == gameID && db.teamOf(record.playerID) == teamID {
+			points += record.stats["points"]
+		}
+	}
+	return points
+}
+
+func (db *fakeDB) teamName(id int) string {
+	for _, team := range db.teams {
+		if team.ID == id {
+			return team.Name
+		}
+	}
+	return ""
+}
+
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// sortRecords orders the records by game date and ID, as the records of a player are numbered
+func sortRecords(records []fakeRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].date.Equal(records[j].date) {
+			return records[i].date.Before(records[j].date)
+		}
+		return records[i].id < records[j].id
+	})
+}
+
+var (
+	playerIDPattern  = regexp.MustCompile(`r\.player_id=\$(\d+)`)
+	teamIDPattern    = regexp.MustCompile(`p\.team_id=\$(\d+)`)
+	gameDatePattern  = regexp.MustCompile(`r\.game_date <= \$(\d+)::date`)
+	createdAtPattern = regexp.MustCompile(`r\.created_at <= \$(\d+)`)
+	daysPattern      = regexp.MustCompile(`r\.game_date > (?:CURRENT_DATE|\$\d+::date) - \$(\d+)::int`)
+	limitPattern     = regexp.MustCompile(`LIMIT \$(\d+)`)
+)
+
+// arg returns the argument of the placeholder captured by the pattern in the query, and false if the pattern does not match
+func arg(pattern *regexp.Regexp, query string, args []interface{}) (interface{}, bool) {
+	match := pattern.FindStringSubmatch(query)
+	if match == nil {
+		return nil, false
+	}
+	n, _ := strconv.Atoi(match[1])
+	return args[n-1], true
+}
+
+// filterConditions interprets the conditions of a filter, see Filter.conditions, returning whether a record passes them
+func filterConditions(query string, args []interface{}) func(record fakeRecord) bool {
+	end := time.Now().Truncate(24 * time.Hour)
+	if date, ok := arg(gameDatePattern, query, args); ok {
+		end, _ = time.Parse(time.DateOnly, date.(string))
+	}
+	createdAt, byCreatedAt := arg(createdAtPattern, query, args)
+	days, byDays := arg(daysPattern, query, args)
+	dated := strings.Contains(query, "game_date IS NOT NULL")
+	return func(record fakeRecord) bool {
+		if dated && record.date.IsZero() || record.date.After(end) || byCreatedAt && record.createdAt.After(createdAt.(time.Time)) {
+			return false
+		}
+		return !byDays || record.date.After(end.AddDate(0, 0, -days.(int)))
+	}
+}
+
+// selectRecords interprets the records query of a player or team, see recordsQuery, returning the records in game order
+func (db *fakeDB) selectRecords(query string, args []interface{}) []fakeRecord {
+	var selected []fakeRecord
+	playerID, byPlayer := arg(playerIDPattern, query, args)
+	teamID, _ := arg(teamIDPattern, query, args)
+	passes := filterConditions(query, args)
+
+	for _, record := range db.records {
+		if byPlayer && record.playerID != playerID.(int) || !byPlayer && db.teamOf(record.playerID) != teamID.(int) {
+			continue
+		}
+		if passes(record) {
+			selected = append(selected, record)
+		}
+	}
+	sortRecords(selected)
+
+	if games, ok := arg(limitPattern, query, args); ok {
+		if byPlayer {
+			selected = selected[max(len(selected)-games.(int), 0):]
+		} else {
+			dates := gameDates(selected)
+			dates = dates[max(len(dates)-games.(int), 0):]
+			var last []fakeRecord
+			for _, record := range selected {
+				if len(dates) > 0 && !record.date.Before(dates[0]) {
+					last = append(last, record)
+				}
+			}
+			selected = last
+		}
+	}
+	return selected
+}
+
+// gameDates returns the distinct dates of the records in game order
+func gameDates(records []fakeRecord) []time.Time {
+	var dates []time.Time
+	for _, record := range records {
+		if len(dates) == 0 || !dates[len(dates)-1].Equal(record.date) {
+			dates = append(dates, record.date)
+		}
+	}
+	return dates
+}
+
+// aggregateRow returns the aggregate columns of the records, see aggregateColumns,
+// counting the games of a team by date
+func aggregateRow(records []fakeRecord, team bool) []interface{} {
+	row := []interface{}{len(records)}
+	if team {
+		row = []interface{}{len(gameDates(records))}
+	}
+	for _, stat := range RecordStats {
+		var sum float64
+		for _, record := range records {
+			sum += record.stats[stat]
+		}
+		if len(records) > 0 {
+			sum /= float64(len(records))
+		}
+		row = append(row, sum)
+	}
+	var doubleDoubles, tripleDoubles int
+	for _, record := range records {
+		if record.doubleDigits() >= 2 {
+			doubleDoubles++
+		}
+		if record.doubleDigits() >= 3 {
+			tripleDoubles++
+		}
+	}
+	return append(row, doubleDoubles, tripleDoubles)
+}
+
+func (db *fakeDB) recordsOf(id int, team bool) []fakeRecord {
+	var records []fakeRecord
+	for _, record := range db.records {
+		if !team && record.playerID == id || team && db.teamOf(record.playerID) == id {
+			records = append(records, record)
+		}
+	}
+	sortRecords(records)
+	return records
+}
+
+// queryTotals interprets totalsQuery, averaging the statistics of the stored totals per record
+func (db *fakeDB) queryTotals(query string, args []interface{}) [][]interface{} {
+	table := "player_totals"
+	if strings.Contains(query, "team_totals") {
+		table = "team_totals"
+	}
+	totals, ok := db.totals[table][args[0].(int)]
+	if !ok || totals.records == 0 {
+		return nil
+	}
+	row := []interface{}{totals.games}
+	for _, stat := range RecordStats {
+		row = append(row, totals.sums[stat]/float64(totals.records))
+	}
+	return [][]interface{}{append(row, totals.doubleDoubles, totals.tripleDoubles)}
+}
+
+func (db *fakeDB) teamRecordsOn(args []interface{}) [][]interface{} {
+	date, _ := time.Parse(time.DateOnly, args[1].(string))
+	count := 0
+	for _, record := range db.recordsOf(args[0].(int), true) {
+		if record.date.Equal(date) && record.id != args[2].(int) {
+			count++
+		}
+	}
+	return [][]interface{}{{count}}
+}
+
+func (db *fakeDB) viewAggregates(teams bool) [][]interface{} {
+	var ids []int
+	if teams {
+		for _, team := range db.teams {
+			ids = append(ids, team.ID)
+		}
+	} else {
+		for _, player := range db.players {
+			ids = append(ids, player.id)
+		}
+	}
+	sort.Ints(ids)
+	var rows [][]interface{}
+	for _, id := range ids {
+		rows = append(rows, append([]interface{}{id}, aggregateRow(db.recordsOf(id, teams), teams)...))
+	}
+	return rows
+}
+
+func (db *fakeDB) splits(query string, args []interface{}) [][]interface{} {
+	records := db.selectRecords(query, args)
+	groups := make(map[string][]fakeRecord)
+	if strings.Contains(query, "JOIN team_games g") {
+		// The game dimensions, see gameSplits
+		for _, record := range records {
+			if record.gameID == 0 {
+				continue
+			}
+			home, opponentID, result := db.teamGame(record.gameID, db.teamOf(record.playerID))
+			var split string
+			switch {
+			case strings.Contains(query, "g.home"):
+				split = map[bool]string{true: "home", false: "away"}[home]
+			case strings.Contains(query, "g.result"):
+				split = map[string]string{"W": "win", "L": "loss"}[result]
+			default:
+				split = db.teamName(opponentID)
+			}
+			if split != "" {
+				groups[split] = append(groups[split], record)
+			}
+		}
+	} else if strings.HasPrefix(query, "WITH s AS") {
+		rest := make(map[time.Time]string)
+		dates := gameDates(records)
+		for i, date := range dates {
+			if i == 0 {
+				rest[date] = "first"
+			} else if days := int(date.Sub(dates[i-1]).Hours()/24) - 1; days >= 3 {
+				rest[date] = "3+"
+			} else {
+				rest[date] = strconv.Itoa(days)
+			}
+		}
+		for _, record := range records {
+			groups[rest[record.date]] = append(groups[rest[record.date]], record)
+		}
+	} else {
+		for _, record := range records {
+			month := record.date.Format("2006-01")
+			groups[month] = append(groups[month], record)
+		}
+	}
+
+	var splits []string
+	for split := range groups {
+		splits = append(splits, split)
+	}
+	sort.Strings(splits)
+	var rows [][]interface{}
+	for _, split := range splits {
+		rows = append(rows, append([]interface{}{split}, aggregateRow(groups[split], strings.Contains(query, teamGamesCount))...))
+	}
+	return rows
+}
+
+var (
+	seriesStatPattern      = regexp.MustCompile(`(?:SUM\(r\.|r\.)(\w+)\)?::float8 AS value`)
+	seriesPrecedingPattern = regexp.MustCompile(`ROWS BETWEEN \$(\d+)::int PRECEDING`)
+)
+
+func (db *fakeDB) series(query string, args []interface{}) [][]interface{} {
+	stat := seriesStatPattern.FindStringSubmatch(query)[1]
+	team := strings.Contains(query, "p.team_id=$1")
+	records := db.selectRecords(query, args)
+
+	var dates []time.Time
+	var values []float64
+	for _, record := range records {
+		if team && len(dates) > 0 && dates[len(dates)-1].Equal(record.date) {
+			values[len(values)-1] += record.stats[stat]
+			continue
+		}
+		dates = append(dates, record.date)
+		values = append(values, record.stats[stat])
+	}
+
+	var rows [][]interface{}
+	preceding, _ := arg(seriesPrecedingPattern, query, args)
+	for i, value := range values {
+		var sum float64
+		window := values[max(i-preceding.(int), 0) : i+1]
+		for _, v := range window {
+			sum += v
+		}
+		rows = append(rows, []interface{}{dates[i], value, sum / float64(len(window))})
+	}
+	return rows
+}
+
+// fantasyScore interprets scoreExpression, whose arguments are the weights of RecordStats followed by the bonuses
+func fantasyScore(record fakeRecord, args []interface{}) float64 {
+	var score float64
+	for i, stat := range RecordStats {
+		score += record.stats[stat] * args[i].(float64)
+	}
+	switch bonuses := args[len(RecordStats):]; {
+	case record.doubleDigits() >= 3:
+		score += bonuses[0].(float64)
+	case record.doubleDigits() >= 2:
+		score += bonuses[1].(float64)
+	}
+	return score
+}
+
+func averageScore(records []fakeRecord, args []interface{}) float64 {
+	if len(records) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, record := range records {
+		sum += fantasyScore(record, args)
+	}
+	return sum / float64(len(records))
+}
+
+func (db *fakeDB) fantasy(query string, args []interface{}) [][]interface{} {
+	records := db.selectRecords(query, args)
+	return [][]interface{}{{len(records), averageScore(records, args)}}
+}
+
+func (db *fakeDB) fantasyLeaders(query string, args []interface{}) [][]interface{} {
+	team := strings.Contains(query, "GROUP BY p.team_id")
+	passes := filterConditions(query, args)
+	groups := make(map[int][]fakeRecord)
+	for _, record := range db.records {
+		if !passes(record) {
+			continue
+		}
+		id := record.playerID
+		if team {
+			id = db.teamOf(id)
+		}
+		groups[id] = append(groups[id], record)
+	}
+	var rows [][]interface{}
+	for id, records := range groups {
+		games := len(records)
+		if team {
+			sortRecords(records)
+			games = len(gameDates(records))
+		}
+		rows = append(rows, []interface{}{id, games, averageScore(records, args)})
+	}
+	return rows
+}
+
+func (db *fakeDB) career(args []interface{}) [][]interface{} {
+	date, _ := time.Parse(time.DateOnly, args[1].(string))
+	games, highs, totals := 0, make(map[string]float64), make(map[string]float64)
+	for _, record := range db.recordsOf(args[0].(int), false) {
+		if record.date.IsZero() || record.date.After(date) || record.date.Equal(date) && record.id >= args[2].(int) {
+			continue
+		}
+		games++
+		for _, stat := range milestoneStats {
+			highs[stat] = max(highs[stat], record.stats[stat])
+			totals[stat] += record.stats[stat]
+		}
+	}
+	row := []interface{}{games}
+	for _, stat := range milestoneStats {
+		row = append(row, highs[stat], totals[stat])
+	}
+	return [][]interface{}{row}
+}
+
+var (
+	eventPlayerPattern = regexp.MustCompile(`e\.player_id=\$(\d+)`)
+	eventTypePattern   = regexp.MustCompile(`e\.type=\$(\d+)`)
+)
+
+func (db *fakeDB) queryEvents(query string, args []interface{}) [][]interface{} {
+	playerID, byPlayer := arg(eventPlayerPattern, query, args)
+	eventType, byType := arg(eventTypePattern, query, args)
+	limit, _ := arg(limitPattern, query, args)
+
+	events := append([]fakeEvent{}, db.events...)
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Date != events[j].Date {
+			return events[i].Date > events[j].Date
+		}
+		return events[i].ID > events[j].ID
+	})
+	var rows [][]interface{}
+	for _, event := range events {
+		if byPlayer && event.PlayerID != playerID.(int) || byType && event.Type != eventType.(string) || len(rows) == limit.(int) {
+			continue
+		}
+		date, _ := time.Parse(time.DateOnly, event.Date)
+		rows = append(rows, []interface{}{event.ID, event.PlayerID, event.Type, event.Value, date})
+	}
+	return rows
+}
+
+func (db *fakeDB) recentRecords(query string, args []interface{}) [][]interface{} {
+	records := db.selectRecords(query, args)
+	var rows [][]interface{}
+	for i := len(records) - 1; i >= 0; i-- {
+		var row []interface{}
+		for _, stat := range RecordStats {
+			row = append(row, records[i].stats[stat])
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// allRecords returns the records passing the filter, or all records if it is nil, by player and game order
+func (db *fakeDB) allRecords(filter func(record fakeRecord) bool) [][]interface{} {
+	var records []fakeRecord
+	for _, record := range db.records {
+		if filter == nil || filter(record) {
+			records = append(records, record)
+		}
+	}
+	sortRecords(records)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].playerID < records[j].playerID
+	})
+	var rows [][]interface{}
+	for _, record := range records {
+		row := []interface{}{record.id, record.playerID}
+		for _, stat := range RecordStats {
+			row = append(row, record.stats[stat])
+		}
+		rows = append(rows, append(row, record.date))
+	}
+	return rows
+}
+
+// fakeRows scans the values of its rows into the destinations, converting them like the driver does
+type fakeRows struct {
+	rows  [][]interface{}
+	index int
+}
+
+func (r *fakeRows) Close() {}
+
+func (r *fakeRows) Err() error {
+	return nil
+}
+
+func (r *fakeRows) Next() bool {
+	r.index++
+	return r.index < len(r.rows)
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	row := r.rows[r.index]
+	if len(dest) != len(row) {
+		return fmt.Errorf("fake: scanning %d columns into %d destinations", len(row), len(dest))
+	}
+	for i, value := range row {
+		target := reflect.ValueOf(dest[i]).Elem()
+		v := reflect.ValueOf(value)
+		if !v.Type().ConvertibleTo(target.Type()) {
+			return fmt.Errorf("fake: cannot scan %T into %s", value, target.Type())
+		}
+		target.Set(v.Convert(target.Type()))
+	}
+	return nil
+}
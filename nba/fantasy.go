@@ -0,0 +1,274 @@
+package nba
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FantasyProfile scores a record as the weighted sum of its statistics plus a bonus for a double-double,
+// or a triple-double bonus instead for a triple-double. Penalties are negative weights.
+type FantasyProfile struct {
+	Name              string
+	Weights           map[string]float64
+	DoubleDoubleBonus float64
+	TripleDoubleBonus float64
+}
+
+type FantasyRecord struct {
+	ID            int     `json:"id"`
+	Name          string  `json:"name"`
+	Profile       string  `json:"profile"`
+	Games         int     `json:"games"`
+	FantasyPoints float64 `json:"fantasyPoints"`
+}
+
+func GetFantasyProfiles(db Database) (map[string]FantasyProfile, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT name, %s, double_double_bonus, triple_double_bonus FROM fantasy_profiles", strings.Join(RecordStats, ", ")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := make(map[string]FantasyProfile)
+	for rows.Next() {
+		profile := FantasyProfile{Weights: make(map[string]float64)}
+		weights := make([]float64, len(RecordStats))
+		dest := []interface{}{&profile.Name}
+		for i := range weights {
+			dest = append(dest, &weights[i])
+		}
+		dest = append(dest, &profile.DoubleDoubleBonus, &profile.TripleDoubleBonus)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i, stat := range RecordStats {
+			profile.Weights[stat] = weights[i]
+		}
+		profiles[profile.Name] = profile
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return profiles, nil
+}
+
+// scoreExpression returns the SQL expression of the fantasy points of a record r, adding the weights to args
+func (f FantasyProfile) scoreExpression(args *queryArgs) string {
+	var terms []string
+	for _, stat := range RecordStats {
+		terms = append(terms, fmt.Sprintf("r.%s * %s::float8", stat, args.add(f.Weights[stat])))
+	}
+	terms = append(terms, fmt.Sprintf("CASE WHEN %[1]s >= 3 THEN %[2]s::float8 WHEN %[1]s >= 2 THEN %[3]s::float8 ELSE 0 END", doubleDigitsCount, args.add(f.TripleDoubleBonus), args.add(f.DoubleDoubleBonus)))
+	return "(" + strings.Join(terms, " + ") + ")"
+}
+
+func (f FantasyProfile) cacheKey(a AggregatedObject, filter Filter) string {
+	return fmt.Sprintf("fantasy_%s_%s", f.Name, a.CacheKey(filter))
+}
+
+func (f FantasyProfile) leadersCacheKey(kind string, filter Filter) string {
+	return versionedKey(fmt.Sprintf("fantasy_%s_%s%s", f.Name, kind, filter.cacheKeySuffix()))
+}
+
+func (f FantasyProfile) DBQuery(a AggregatedObject, filter Filter) Query {
+	var args queryArgs
+	score := f.scoreExpression(&args)
+	return Query{fmt.Sprintf(`SELECT COUNT(r.id), COALESCE(AVG(%s), 0)::float8 FROM (%s) r;`, score, a.recordsQuery(filter, &args)), args}
+}
+
+// LeadersDBQuery returns the query of the average fantasy points of all players or teams over the records that pass the filter
+func (f FantasyProfile) LeadersDBQuery(kind string, filter Filter) Query {
+	var args queryArgs
+	score := f.scoreExpression(&args)
+	conditions := filter.conditions(&args)
+	if kind == "teams" {
+		return Query{fmt.Sprintf(`SELECT p.team_id, COUNT(DISTINCT r.game_date), AVG(%s)::float8 FROM records r JOIN players p ON r.player_id = p.id WHERE TRUE%s GROUP BY p.team_id;`, score, conditions), args}
+	}
+	return Query{fmt.Sprintf(`SELECT r.player_id, COUNT(r.id), AVG(%s)::float8 FROM records r WHERE TRUE%s GROUP BY r.player_id;`, score, conditions), args}
+}
+
+func (nba *NBAStatistics) getFantasyData(f FantasyProfile, a AggregatedObject, filter Filter) ([]byte, error) {
+	return nba.cached(EndpointPlayerFantasy, CacheFantasy, f.cacheKey(a, filter), filter.immutable(), func() ([]byte, error) {
+		aggregate := a.NewAggregatedRecord()
+		record := FantasyRecord{ID: aggregate.ID, Name: aggregate.Name, Profile: f.Name}
+		query := f.DBQuery(a, filter)
+		queryResult, err := nba.db.Query(query.SQL, query.Args...)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get data for %s", f.cacheKey(a, filter))
+		}
+		defer queryResult.Close()
+		if queryResult.Next() {
+			if err = queryResult.Scan(&record.Games, &record.FantasyPoints); err != nil {
+				return nil, err
+			}
+		}
+
+		return json.Marshal(record)
+	})
+}
+
+// getFantasyLeadersData returns the players or teams with records ranked by their average fantasy points
+func (nba *NBAStatistics) getFantasyLeadersData(f FantasyProfile, kind string, objects map[int]AggregatedObject, filter Filter) ([]FantasyRecord, error) {
+	// Check cache first
+	var records []FantasyRecord
+	if nba.cacheEnabled(EndpointFantasyLeaders) {
+		cachedResult, err := nba.cache.Get(f.leadersCacheKey(kind, filter))
+		if err == nil {
+			if err = json.Unmarshal([]byte(cachedResult), &records); err == nil {
+				return records, nil
+			}
+		}
+	}
+
+	query := f.LeadersDBQuery(kind, filter)
+	queryResult, err := nba.db.Query(query.SQL, query.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get data for %s", f.leadersCacheKey(kind, filter))
+	}
+	defer queryResult.Close()
+
+	records = []FantasyRecord{}
+	for queryResult.Next() {
+		record := FantasyRecord{Profile: f.Name}
+		if err := queryResult.Scan(&record.ID, &record.Games, &record.FantasyPoints); err != nil {
+			return nil, err
+		}
+		object, exists := objects[record.ID]
+		if !exists {
+			continue
+		}
+		record.Name = object.NewAggregatedRecord().Name
+		records = append(records, record)
+	}
+	if err := queryResult.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].FantasyPoints > records[j].FantasyPoints
+	})
+
+	result, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+
+	// Put the result to cache
+	if nba.cacheEnabled(EndpointFantasyLeaders) {
+		err = nba.cache.Set(f.leadersCacheKey(kind, filter), result, nba.cacheTTL(CacheFantasy, filter.immutable()))
+	}
+
+	return records, err
+}
+
+// invalidateFantasy drops the cached fantasy points of the player and their team in all profiles
+func (nba *NBAStatistics) invalidateFantasy(player Player) error {
+	for _, f := range nba.profiles {
+		for _, key := range []string{f.cacheKey(player, Filter{}), f.cacheKey(player.Team, Filter{})} {
+			if err := nba.invalidateKey(CacheFantasy, key); err != nil {
+				return err
+			}
+		}
+		for _, key := range []string{f.leadersCacheKey("players", Filter{}), f.leadersCacheKey("teams", Filter{})} {
+			if err := nba.cache.Del(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (nba *NBAStatistics) fantasyProfile(r *http.Request) (FantasyProfile, error) {
+	name := r.URL.Query().Get("profile")
+	profile, exists := nba.profiles[name]
+	if !exists {
+		return FantasyProfile{}, fmt.Errorf("fantasy profile %q does not exist", name)
+	}
+	return profile, nil
+}
+
+func (nba *NBAStatistics) GetPlayerFantasy(w http.ResponseWriter, r *http.Request) {
+	profile, err := nba.fantasyProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	playerIDStr := r.URL.Query().Get("playerId")
+	playerID, err := strconv.Atoi(playerIDStr)
+	if err != nil {
+		http.Error(w, "Invalid playerId", http.StatusBadRequest)
+		return
+	}
+
+	player, exists := nba.players[playerID]
+	if !exists {
+		http.Error(w, fmt.Sprintf("player with ID %d does not exist", playerID), http.StatusBadRequest)
+		return
+	}
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := nba.getFantasyData(profile, player, Filter{AsOf: asOf})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func (nba *NBAStatistics) GetFantasyLeaders(w http.ResponseWriter, r *http.Request) {
+	profile, err := nba.fantasyProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	kind := r.URL.Query().Get("type")
+	if kind == "" {
+		kind = "players"
+	}
+	objects, ok := nba.leaderObjects(kind)
+	if !ok {
+		http.Error(w, "Invalid type", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultLeadersLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := nba.getFantasyLeadersData(profile, kind, objects, Filter{AsOf: asOf})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(records) > limit {
+		records = records[:limit]
+	}
+
+	resultJSON, _ := json.Marshal(records)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultJSON)
+}
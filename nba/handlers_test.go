@@ -0,0 +1,647 @@
+package nba
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/cache"
+)
+
+func statLine(points, rebounds, assists, steals, blocks, turnovers, fouls, minutes float64) map[string]float64 {
+	return map[string]float64{"points": points, "rebounds": rebounds, "assists": assists, "steals": steals, "blocks": blocks, "turnovers": turnovers, "fouls": fouls, "minutes": minutes}
+}
+
+// newSeededDB returns a fake database with three teams, one of them without players, five players, one of them without records,
+// a game between the first two teams and a standard fantasy profile
+func newSeededDB() *fakeDB {
+	db := newFakeDB()
+	db.teams = []Team{{ID: 1, Name: "Lakers", Conference: "West", Division: "Pacific"}, {ID: 2, Name: "Warriors", Conference: "West", Division: "Pacific"},
+		{ID: 3, Name: "Celtics", Conference: "East", Division: "Atlantic"}}
+	db.players = []fakePlayer{{1, "LeBron James", 1}, {2, "Anthony Davis", 1}, {3, "Stephen Curry", 2}, {4, "Klay Thompson", 2}, {5, "Draymond Green", 2}}
+	db.profiles = []FantasyProfile{{
+		Name:              "standard",
+		Weights:           map[string]float64{"points": 1, "rebounds": 1.2, "assists": 1.5, "steals": 3, "blocks": 3, "turnovers": -1},
+		DoubleDoubleBonus: 1.5,
+		TripleDoubleBonus: 3,
+	}}
+	db.addRecord(1, "2025-01-10", statLine(30, 10, 8, 1, 1, 3, 2, 36))
+	db.addRecord(2, "2025-01-10", statLine(22, 12, 3, 1, 3, 2, 4, 34))
+	db.addRecord(3, "2025-01-11", statLine(35, 5, 7, 2, 0, 3, 2, 34))
+	db.addRecord(4, "2025-01-11", statLine(18, 4, 2, 1, 1, 1, 3, 30))
+	db.addRecord(1, "2025-01-12", statLine(25, 8, 12, 2, 0, 4, 1, 35))
+	db.addRecord(1, "2025-02-01", statLine(41, 11, 10, 1, 2, 5, 3, 38))
+	db.addGame("2025-01-12", 1, 2)
+	db.events = []fakeEvent{
+		{Event: Event{ID: 1, PlayerID: 1, Type: EventTripleDouble, Value: 3, Date: "2025-02-01"}, recordID: 6},
+		{Event: Event{ID: 2, PlayerID: 1, Type: EventFortyPoints, Value: 41, Date: "2025-02-01"}, recordID: 6},
+		{Event: Event{ID: 3, PlayerID: 2, Type: EventDoubleDouble, Value: 2, Date: "2025-01-10"}, recordID: 2},
+	}
+	db.backfillTotals("player_totals")
+	db.backfillTotals("team_totals")
+	return db
+}
+
+func newTestNBAStatistics(t *testing.T, db *fakeDB) *NBAStatistics {
+	t.Helper()
+	nba, err := NewNBAStatistics(cache.NewMemoryCache(1000), db, Options{})
+	if err != nil {
+		t.Fatalf("NewNBAStatistics: %v", err)
+	}
+	return nba
+}
+
+func serve(nba *NBAStatistics, handler func(*NBAStatistics, http.ResponseWriter, *http.Request), method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(nba, w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func TestHandlers(t *testing.T) {
+	type handler = func(*NBAStatistics, http.ResponseWriter, *http.Request)
+	tests := []struct {
+		name       string
+		handler    handler
+		method     string
+		target     string
+		body       string
+		fail       string
+		wantStatus int
+		want       []string
+	}{
+		// AddRecord
+		{name: "add record", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": 3, "points": 28, "rebounds": 4, "assists": 6, "minutes": 33, "date": "2025-02-02"}`, wantStatus: http.StatusCreated},
+		{name: "add record invalid json", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": `, wantStatus: http.StatusBadRequest},
+		{name: "add record unknown player", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": 99, "points": 10}`, wantStatus: http.StatusBadRequest, want: []string{"player with ID 99 does not exist"}},
+		{name: "add record too many fouls", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": 1, "fouls": 7}`, wantStatus: http.StatusBadRequest, want: []string{"fouls cannot be greater than 6"}},
+		{name: "add record future date", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": 1, "date": "2999-01-01"}`, wantStatus: http.StatusBadRequest, want: []string{"date cannot be in the future"}},
+		{name: "add record with game", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": 3, "points": 20, "date": "2025-01-12", "opponentId": 1, "home": false}`, wantStatus: http.StatusCreated},
+		{name: "add record opponent without home", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": 3, "opponentId": 1}`, wantStatus: http.StatusBadRequest, want: []string{"opponentId and home must be given together"}},
+		{name: "add record unknown opponent", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": 3, "opponentId": 99, "home": true}`, wantStatus: http.StatusBadRequest, want: []string{"team with ID 99 does not exist"}},
+		{name: "add record against own team", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": 3, "opponentId": 2, "home": true}`, wantStatus: http.StatusBadRequest, want: []string{"opponentId must differ"}},
+		{name: "add record conflicting game", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": 3, "date": "2025-01-12", "opponentId": 1, "home": true}`, wantStatus: http.StatusConflict, want: []string{"already has another game on 2025-01-12"}},
+		{name: "add record game failure", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": 3, "opponentId": 1, "home": true}`, fail: "INSERT INTO games", wantStatus: http.StatusInternalServerError},
+		{name: "add record db failure", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": 1, "points": 10}`, fail: "INSERT INTO records", wantStatus: http.StatusInternalServerError},
+		{name: "add record events failure", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": 1, "points": 40, "rebounds": 10}`, fail: "INSERT INTO events", wantStatus: http.StatusInternalServerError},
+		{name: "add record totals failure", handler: (*NBAStatistics).AddRecord, method: "POST", target: "/record", body: `{"id": 1, "points": 10}`, fail: "INSERT INTO team_totals", wantStatus: http.StatusInternalServerError},
+
+		// GetPlayerAggregate and GetTeamAggregate
+		{name: "player aggregate", handler: (*NBAStatistics).GetPlayerAggregate, method: "GET", target: "/aggregate/player?playerId=1", wantStatus: http.StatusOK, want: []string{`"name":"LeBron James"`, `"games":3`, `"points":32`, `"doubleDoubles":3`, `"tripleDoubles":1`}},
+		{name: "player aggregate last games", handler: (*NBAStatistics).GetPlayerAggregate, method: "GET", target: "/aggregate/player?playerId=1&window=last5", wantStatus: http.StatusOK, want: []string{`"games":3`, `"points":32`}},
+		{name: "player aggregate as of", handler: (*NBAStatistics).GetPlayerAggregate, method: "GET", target: "/aggregate/player?playerId=1&asOf=2025-01-11", wantStatus: http.StatusOK, want: []string{`"games":1`, `"points":30`}},
+		{name: "player aggregate without records", handler: (*NBAStatistics).GetPlayerAggregate, method: "GET", target: "/aggregate/player?playerId=5", wantStatus: http.StatusOK, want: []string{`"games":0`}},
+		{name: "player aggregate invalid id", handler: (*NBAStatistics).GetPlayerAggregate, method: "GET", target: "/aggregate/player?playerId=x", wantStatus: http.StatusBadRequest, want: []string{"Invalid playerId"}},
+		{name: "player aggregate unknown id", handler: (*NBAStatistics).GetPlayerAggregate, method: "GET", target: "/aggregate/player?playerId=99", wantStatus: http.StatusBadRequest, want: []string{"player with ID 99 does not exist"}},
+		{name: "player aggregate invalid window", handler: (*NBAStatistics).GetPlayerAggregate, method: "GET", target: "/aggregate/player?playerId=1&window=season", wantStatus: http.StatusBadRequest},
+		{name: "player aggregate as of today", handler: (*NBAStatistics).GetPlayerAggregate, method: "GET", target: "/aggregate/player?playerId=1&asOf=" + time.Now().UTC().Format(time.DateOnly), wantStatus: http.StatusOK, want: []string{`"games":3`}},
+		{name: "player aggregate future asOf", handler: (*NBAStatistics).GetPlayerAggregate, method: "GET", target: "/aggregate/player?playerId=1&asOf=2999-01-01", wantStatus: http.StatusBadRequest, want: []string{"asOf must be in the past"}},
+		{name: "player aggregate db failure", handler: (*NBAStatistics).GetPlayerAggregate, method: "GET", target: "/aggregate/player?playerId=1", fail: "FROM player_totals", wantStatus: http.StatusInternalServerError},
+		{name: "team aggregate", handler: (*NBAStatistics).GetTeamAggregate, method: "GET", target: "/aggregate/team?teamId=1", wantStatus: http.StatusOK, want: []string{`"name":"Lakers"`, `"games":3`, `"points":29.5`}},
+		{name: "team aggregate last games", handler: (*NBAStatistics).GetTeamAggregate, method: "GET", target: "/aggregate/team?teamId=1&window=last5", wantStatus: http.StatusOK, want: []string{`"games":3`, `"points":29.5`}},
+		{name: "team aggregate invalid id", handler: (*NBAStatistics).GetTeamAggregate, method: "GET", target: "/aggregate/team?teamId=x", wantStatus: http.StatusBadRequest, want: []string{"Invalid teamId"}},
+		{name: "team aggregate unknown id", handler: (*NBAStatistics).GetTeamAggregate, method: "GET", target: "/aggregate/team?teamId=99", wantStatus: http.StatusBadRequest, want: []string{"team with ID 99 does not exist"}},
+		{name: "team aggregate db failure", handler: (*NBAStatistics).GetTeamAggregate, method: "GET", target: "/aggregate/team?teamId=1&window=last10", fail: "SELECT COUNT(DISTINCT r.game_date) AS games", wantStatus: http.StatusInternalServerError},
+
+		// GetAllPlayersAggregate and GetAllTeamsAggregate
+		{name: "all players aggregate", handler: (*NBAStatistics).GetAllPlayersAggregate, method: "GET", target: "/aggregate/players", wantStatus: http.StatusOK, want: []string{`"name":"Stephen Curry"`, `"name":"Draymond Green"`}},
+		{name: "all players aggregate stale view", handler: (*NBAStatistics).GetAllPlayersAggregate, method: "GET", target: "/aggregate/players?maxStaleness=5m", wantStatus: http.StatusOK, want: []string{`"name":"Klay Thompson"`}},
+		{name: "all players aggregate invalid maxStaleness", handler: (*NBAStatistics).GetAllPlayersAggregate, method: "GET", target: "/aggregate/players?maxStaleness=soon", wantStatus: http.StatusBadRequest},
+		{name: "all players aggregate db failure", handler: (*NBAStatistics).GetAllPlayersAggregate, method: "GET", target: "/aggregate/players", fail: "FROM player_totals", wantStatus: http.StatusInternalServerError},
+		{name: "all teams aggregate", handler: (*NBAStatistics).GetAllTeamsAggregate, method: "GET", target: "/aggregate/teams", wantStatus: http.StatusOK, want: []string{`"name":"Lakers"`, `"name":"Warriors"`}},
+		{name: "all teams aggregate view failure", handler: (*NBAStatistics).GetAllTeamsAggregate, method: "GET", target: "/aggregate/teams?maxStaleness=1m", fail: "aggregate_view_refreshes", wantStatus: http.StatusInternalServerError},
+
+		// GetPlayerSplits and GetTeamSplits
+		{name: "player splits", handler: (*NBAStatistics).GetPlayerSplits, method: "GET", target: "/aggregate/player/splits?playerId=1", wantStatus: http.StatusOK, want: []string{`"split":"2025-01"`, `"split":"2025-02"`, `"split":"first"`, `"split":"1"`, `"split":"3+"`, `"points":27.5`}},
+		{name: "player game splits", handler: (*NBAStatistics).GetPlayerSplits, method: "GET", target: "/aggregate/player/splits?playerId=1", wantStatus: http.StatusOK, want: []string{`"home":[{"split":"home","id":1,"name":"LeBron James","games":1,"points":25`, `"result":[{"split":"win"`, `"opponent":[{"split":"Warriors"`}},
+		{name: "player splits unknown id", handler: (*NBAStatistics).GetPlayerSplits, method: "GET", target: "/aggregate/player/splits?playerId=99", wantStatus: http.StatusBadRequest},
+		{name: "player splits db failure", handler: (*NBAStatistics).GetPlayerSplits, method: "GET", target: "/aggregate/player/splits?playerId=1", fail: "AS split", wantStatus: http.StatusInternalServerError},
+		{name: "team splits", handler: (*NBAStatistics).GetTeamSplits, method: "GET", target: "/aggregate/team/splits?teamId=2", wantStatus: http.StatusOK, want: []string{`"split":"2025-01"`, `"games":1,"points":26.5`}},
+		{name: "team splits invalid id", handler: (*NBAStatistics).GetTeamSplits, method: "GET", target: "/aggregate/team/splits?teamId=x", wantStatus: http.StatusBadRequest},
+
+		// GetPlayerSeries and GetTeamSeries
+		{name: "player series", handler: (*NBAStatistics).GetPlayerSeries, method: "GET", target: "/series/player?playerId=1&stat=points&games=2", wantStatus: http.StatusOK, want: []string{`{"date":"2025-01-12","value":25,"average":27.5}`, `{"date":"2025-02-01","value":41,"average":33}`}},
+		{name: "player series invalid stat", handler: (*NBAStatistics).GetPlayerSeries, method: "GET", target: "/series/player?playerId=1&stat=height", wantStatus: http.StatusBadRequest, want: []string{"Invalid stat"}},
+		{name: "player series invalid games", handler: (*NBAStatistics).GetPlayerSeries, method: "GET", target: "/series/player?playerId=1&stat=points&games=0", wantStatus: http.StatusBadRequest, want: []string{"Invalid games"}},
+		{name: "player series unknown id", handler: (*NBAStatistics).GetPlayerSeries, method: "GET", target: "/series/player?playerId=99&stat=points", wantStatus: http.StatusBadRequest},
+		{name: "player series db failure", handler: (*NBAStatistics).GetPlayerSeries, method: "GET", target: "/series/player?playerId=1&stat=points", fail: "AS average", wantStatus: http.StatusInternalServerError},
+		{name: "team series", handler: (*NBAStatistics).GetTeamSeries, method: "GET", target: "/series/team?teamId=1&stat=points&games=1", wantStatus: http.StatusOK, want: []string{`{"date":"2025-01-10","value":52,"average":52}`}},
+		{name: "player series as of", handler: (*NBAStatistics).GetPlayerSeries, method: "GET", target: "/series/player?playerId=1&stat=points&games=2&asOf=2025-01-12", wantStatus: http.StatusOK, want: []string{`[{"date":"2025-01-10","value":30,"average":30},{"date":"2025-01-12","value":25,"average":27.5}]`}},
+		{name: "team series future asOf", handler: (*NBAStatistics).GetTeamSeries, method: "GET", target: "/series/team?teamId=1&stat=points&asOf=2999-01-01", wantStatus: http.StatusBadRequest, want: []string{"asOf must be in the past"}},
+		{name: "team series unknown id", handler: (*NBAStatistics).GetTeamSeries, method: "GET", target: "/series/team?teamId=99&stat=points", wantStatus: http.StatusBadRequest},
+
+		// GetLeaders
+		{name: "leaders", handler: (*NBAStatistics).GetLeaders, method: "GET", target: "/leaders?stat=points&minGames=1&minMinutes=0&limit=2", wantStatus: http.StatusOK, want: []string{`[{"rank":1,"id":3,"name":"Stephen Curry"`, `{"rank":2,"id":1,"name":"LeBron James"`}},
+		{name: "leaders default thresholds", handler: (*NBAStatistics).GetLeaders, method: "GET", target: "/leaders?stat=points", wantStatus: http.StatusOK, want: []string{`[]`}},
+		{name: "team leaders", handler: (*NBAStatistics).GetLeaders, method: "GET", target: "/leaders?stat=rebounds&type=teams", wantStatus: http.StatusOK, want: []string{`"name":"Lakers"`}},
+		{name: "leaders as of", handler: (*NBAStatistics).GetLeaders, method: "GET", target: "/leaders?stat=points&minGames=1&minMinutes=0&limit=2&asOf=2025-01-11", wantStatus: http.StatusOK, want: []string{`[{"rank":1,"id":3,"name":"Stephen Curry","games":1,"value":35},{"rank":2,"id":1,"name":"LeBron James","games":1,"value":30}]`}},
+		{name: "leaders invalid asOf", handler: (*NBAStatistics).GetLeaders, method: "GET", target: "/leaders?stat=points&asOf=yesterday", wantStatus: http.StatusBadRequest, want: []string{"invalid asOf"}},
+		{name: "leaders invalid stat", handler: (*NBAStatistics).GetLeaders, method: "GET", target: "/leaders?stat=height", wantStatus: http.StatusBadRequest, want: []string{"Invalid stat"}},
+		{name: "leaders season", handler: (*NBAStatistics).GetLeaders, method: "GET", target: "/leaders?stat=points&season=2025", wantStatus: http.StatusBadRequest},
+		{name: "leaders invalid type", handler: (*NBAStatistics).GetLeaders, method: "GET", target: "/leaders?stat=points&type=coaches", wantStatus: http.StatusBadRequest, want: []string{"Invalid type"}},
+		{name: "leaders invalid limit", handler: (*NBAStatistics).GetLeaders, method: "GET", target: "/leaders?stat=points&limit=-1", wantStatus: http.StatusBadRequest, want: []string{"Invalid limit"}},
+		{name: "leaders invalid minGames", handler: (*NBAStatistics).GetLeaders, method: "GET", target: "/leaders?stat=points&minGames=x", wantStatus: http.StatusBadRequest, want: []string{"Invalid minGames"}},
+		{name: "leaders invalid minMinutes", handler: (*NBAStatistics).GetLeaders, method: "GET", target: "/leaders?stat=points&minMinutes=x", wantStatus: http.StatusBadRequest, want: []string{"Invalid minMinutes"}},
+		{name: "leaders db failure", handler: (*NBAStatistics).GetLeaders, method: "GET", target: "/leaders?stat=points", fail: "FROM player_totals", wantStatus: http.StatusInternalServerError},
+
+		// Compare
+		{name: "compare players", handler: (*NBAStatistics).Compare, method: "GET", target: "/compare?players=3,1", wantStatus: http.StatusOK, want: []string{`{"records":[{"id":3,"name":"Stephen Curry"`, `{"id":1,"name":"LeBron James"`, `"tripleDoubles":1}]}`}},
+		{name: "compare teams", handler: (*NBAStatistics).Compare, method: "GET", target: "/compare?teams=2,1&window=last5", wantStatus: http.StatusOK, want: []string{`{"records":[{"id":2,"name":"Warriors"`,
+			`"headToHead":{"games":[{"id":1,"date":"2025-01-12","homeTeamId":1,"homePoints":25,"awayTeamId":2,"awayPoints":0,"winnerId":1}],"records":[{"id":2,"name":"Warriors","wins":0,"losses":1},{"id":1,"name":"Lakers","wins":1,"losses":0}]}`}},
+		{name: "compare teams as of", handler: (*NBAStatistics).Compare, method: "GET", target: "/compare?teams=1,2&asOf=2025-01-11", wantStatus: http.StatusOK, want: []string{`"headToHead":{"games":[],"records":[{"id":1,"name":"Lakers","wins":0,"losses":0}`}},
+		{name: "compare teams head-to-head failure", handler: (*NBAStatistics).Compare, method: "GET", target: "/compare?teams=1,2", fail: "FROM team_games", wantStatus: http.StatusInternalServerError},
+		{name: "compare players and teams", handler: (*NBAStatistics).Compare, method: "GET", target: "/compare?players=1&teams=1", wantStatus: http.StatusBadRequest, want: []string{"either players or teams must be given"}},
+		{name: "compare invalid id", handler: (*NBAStatistics).Compare, method: "GET", target: "/compare?players=1,x", wantStatus: http.StatusBadRequest},
+		{name: "compare unknown team", handler: (*NBAStatistics).Compare, method: "GET", target: "/compare?teams=1,99", wantStatus: http.StatusBadRequest, want: []string{"team with ID 99 does not exist"}},
+		{name: "compare db failure", handler: (*NBAStatistics).Compare, method: "GET", target: "/compare?players=1,3", fail: "FROM player_totals", wantStatus: http.StatusInternalServerError},
+
+		// GetStandings
+		{name: "standings", handler: (*NBAStatistics).GetStandings, method: "GET", target: "/standings", wantStatus: http.StatusOK, want: []string{
+			`[{"rank":1,"id":1,"name":"Lakers","conference":"West","division":"Pacific","wins":1,"losses":0,"pct":1,"gamesBehind":0,"home":{"wins":1,"losses":0},"away":{"wins":0,"losses":0},"lastTen":{"wins":1,"losses":0},"streak":"W1"}`,
+			`{"rank":2,"id":3,"name":"Celtics","conference":"East","division":"Atlantic","wins":0,"losses":0,"pct":0,"gamesBehind":0.5,`,
+			`{"rank":3,"id":2,"name":"Warriors","conference":"West","division":"Pacific","wins":0,"losses":1,"pct":0,"gamesBehind":1,"home":{"wins":0,"losses":0},"away":{"wins":0,"losses":1},"lastTen":{"wins":0,"losses":1},"streak":"L1"}]`}},
+		{name: "standings by conference", handler: (*NBAStatistics).GetStandings, method: "GET", target: "/standings?conference=East", wantStatus: http.StatusOK, want: []string{`[{"rank":1,"id":3,"name":"Celtics","conference":"East","division":"Atlantic","wins":0,"losses":0,"pct":0,"gamesBehind":0,`}},
+		{name: "standings by division", handler: (*NBAStatistics).GetStandings, method: "GET", target: "/standings?division=Pacific", wantStatus: http.StatusOK, want: []string{`"name":"Warriors"`}},
+		{name: "standings invalid conference", handler: (*NBAStatistics).GetStandings, method: "GET", target: "/standings?conference=North", wantStatus: http.StatusBadRequest, want: []string{"Invalid conference"}},
+		{name: "standings invalid division", handler: (*NBAStatistics).GetStandings, method: "GET", target: "/standings?division=Central", wantStatus: http.StatusBadRequest, want: []string{"Invalid division"}},
+		{name: "standings db failure", handler: (*NBAStatistics).GetStandings, method: "GET", target: "/standings", fail: "FROM team_games", wantStatus: http.StatusInternalServerError},
+
+		// GetRatings, GetRatingHistory and PredictGame
+		{name: "ratings", handler: (*NBAStatistics).GetRatings, method: "GET", target: "/ratings", wantStatus: http.StatusOK, want: []string{`[{"rank":1,"id":1,"name":"Lakers","rating":1522.434`,
+			`{"rank":2,"id":3,"name":"Celtics","rating":1500,"games":0}`, `{"rank":3,"id":2,"name":"Warriors","rating":1477.565`}},
+		{name: "ratings db failure", handler: (*NBAStatistics).GetRatings, method: "GET", target: "/ratings", fail: "FROM team_games", wantStatus: http.StatusInternalServerError},
+		{name: "ratings history failure", handler: (*NBAStatistics).GetRatings, method: "GET", target: "/ratings", fail: "INSERT INTO team_ratings", wantStatus: http.StatusInternalServerError},
+		{name: "rating history", handler: (*NBAStatistics).GetRatingHistory, method: "GET", target: "/ratings/history?teamId=2", wantStatus: http.StatusOK, want: []string{`[{"gameId":1,"date":"2025-01-12","opponentId":1,"ratingBefore":1500,"rating":1477.565`}},
+		{name: "rating history without games", handler: (*NBAStatistics).GetRatingHistory, method: "GET", target: "/ratings/history?teamId=3", wantStatus: http.StatusOK, want: []string{`[]`}},
+		{name: "rating history unknown team", handler: (*NBAStatistics).GetRatingHistory, method: "GET", target: "/ratings/history?teamId=99", wantStatus: http.StatusBadRequest, want: []string{"team with ID 99 does not exist"}},
+		{name: "rating history db failure", handler: (*NBAStatistics).GetRatingHistory, method: "GET", target: "/ratings/history?teamId=1", fail: "FROM team_ratings r", wantStatus: http.StatusInternalServerError},
+		{name: "predict", handler: (*NBAStatistics).PredictGame, method: "GET", target: "/ratings/predict?home=3&away=1", wantStatus: http.StatusOK, want: []string{`{"home":{"rank":2,"id":3,"name":"Celtics"`, `"away":{"rank":1,"id":1,"name":"Lakers"`, `"homeWinProbability":0.609`}},
+		{name: "predict invalid home", handler: (*NBAStatistics).PredictGame, method: "GET", target: "/ratings/predict?home=x&away=1", wantStatus: http.StatusBadRequest, want: []string{"Invalid home"}},
+		{name: "predict unknown away", handler: (*NBAStatistics).PredictGame, method: "GET", target: "/ratings/predict?home=1&away=99", wantStatus: http.StatusBadRequest, want: []string{"team with ID 99 does not exist"}},
+		{name: "predict same team", handler: (*NBAStatistics).PredictGame, method: "GET", target: "/ratings/predict?home=1&away=1", wantStatus: http.StatusBadRequest, want: []string{"home and away must be different teams"}},
+
+		// GetEvents
+		{name: "events", handler: (*NBAStatistics).GetEvents, method: "GET", target: "/events", wantStatus: http.StatusOK, want: []string{`"type":"40_points"`, `"name":"Anthony Davis"`}},
+		{name: "events by player and type", handler: (*NBAStatistics).GetEvents, method: "GET", target: "/events?playerId=1&type=triple_double", wantStatus: http.StatusOK, want: []string{`[{"id":1,"playerId":1,"name":"LeBron James","type":"triple_double","value":3,"date":"2025-02-01"}]`}},
+		{name: "events limit", handler: (*NBAStatistics).GetEvents, method: "GET", target: "/events?limit=1", wantStatus: http.StatusOK, want: []string{`[{"id":2,`}},
+		{name: "events invalid player", handler: (*NBAStatistics).GetEvents, method: "GET", target: "/events?playerId=x", wantStatus: http.StatusBadRequest, want: []string{"Invalid playerId"}},
+		{name: "events unknown player", handler: (*NBAStatistics).GetEvents, method: "GET", target: "/events?playerId=99", wantStatus: http.StatusBadRequest},
+		{name: "events invalid limit", handler: (*NBAStatistics).GetEvents, method: "GET", target: "/events?limit=0", wantStatus: http.StatusBadRequest, want: []string{"Invalid limit"}},
+		{name: "events db failure", handler: (*NBAStatistics).GetEvents, method: "GET", target: "/events", fail: "FROM events", wantStatus: http.StatusInternalServerError},
+
+		// GetPlayerFantasy and GetFantasyLeaders
+		{name: "player fantasy", handler: (*NBAStatistics).GetPlayerFantasy, method: "GET", target: "/fantasy/player?profile=standard&playerId=1", wantStatus: http.StatusOK, want: []string{`"profile":"standard"`, `"games":3`, `"fantasyPoints":63.6`}},
+		{name: "player fantasy as of", handler: (*NBAStatistics).GetPlayerFantasy, method: "GET", target: "/fantasy/player?profile=standard&playerId=1&asOf=2025-01-10", wantStatus: http.StatusOK, want: []string{`"games":1,"fantasyPoints":58.5`}},
+		{name: "player fantasy unknown profile", handler: (*NBAStatistics).GetPlayerFantasy, method: "GET", target: "/fantasy/player?profile=custom&playerId=1", wantStatus: http.StatusBadRequest, want: []string{`fantasy profile "custom" does not exist`}},
+		{name: "player fantasy unknown player", handler: (*NBAStatistics).GetPlayerFantasy, method: "GET", target: "/fantasy/player?profile=standard&playerId=99", wantStatus: http.StatusBadRequest},
+		{name: "player fantasy db failure", handler: (*NBAStatistics).GetPlayerFantasy, method: "GET", target: "/fantasy/player?profile=standard&playerId=1", fail: "r.points * $1", wantStatus: http.StatusInternalServerError},
+		{name: "fantasy leaders", handler: (*NBAStatistics).GetFantasyLeaders, method: "GET", target: "/fantasy/leaders?profile=standard&limit=1", wantStatus: http.StatusOK, want: []string{`[{"id":1,"name":"LeBron James"`}},
+		{name: "fantasy team leaders", handler: (*NBAStatistics).GetFantasyLeaders, method: "GET", target: "/fantasy/leaders?profile=standard&type=teams", wantStatus: http.StatusOK, want: []string{`"name":"Warriors"`}},
+		{name: "fantasy team leaders as of", handler: (*NBAStatistics).GetFantasyLeaders, method: "GET", target: "/fantasy/leaders?profile=standard&type=teams&asOf=2025-01-10", wantStatus: http.StatusOK, want: []string{`[{"id":1,"name":"Lakers","profile":"standard","games":1,`}},
+		{name: "fantasy leaders invalid type", handler: (*NBAStatistics).GetFantasyLeaders, method: "GET", target: "/fantasy/leaders?profile=standard&type=coaches", wantStatus: http.StatusBadRequest},
+		{name: "fantasy leaders invalid limit", handler: (*NBAStatistics).GetFantasyLeaders, method: "GET", target: "/fantasy/leaders?profile=standard&limit=x", wantStatus: http.StatusBadRequest},
+		{name: "fantasy leaders db failure", handler: (*NBAStatistics).GetFantasyLeaders, method: "GET", target: "/fantasy/leaders?profile=standard", fail: "GROUP BY r.player_id", wantStatus: http.StatusInternalServerError},
+
+		// GetPlayerProjection
+		{name: "projection", handler: (*NBAStatistics).GetPlayerProjection, method: "GET", target: "/projection/player?playerId=1", wantStatus: http.StatusOK, want: []string{`"games":3`, `"points":{"value":`}},
+		{name: "projection as of", handler: (*NBAStatistics).GetPlayerProjection, method: "GET", target: "/projection/player?playerId=1&asOf=2025-01-11", wantStatus: http.StatusOK, want: []string{`"games":1`}},
+		{name: "projection before the first record", handler: (*NBAStatistics).GetPlayerProjection, method: "GET", target: "/projection/player?playerId=1&asOf=2025-01-01", wantStatus: http.StatusBadRequest, want: []string{"has no records to project from"}},
+		{name: "projection without records", handler: (*NBAStatistics).GetPlayerProjection, method: "GET", target: "/projection/player?playerId=5", wantStatus: http.StatusBadRequest, want: []string{"has no records to project from"}},
+		{name: "projection opponent", handler: (*NBAStatistics).GetPlayerProjection, method: "GET", target: "/projection/player?playerId=1&opponent=2", wantStatus: http.StatusBadRequest},
+		{name: "projection invalid id", handler: (*NBAStatistics).GetPlayerProjection, method: "GET", target: "/projection/player?playerId=x", wantStatus: http.StatusBadRequest},
+		{name: "projection db failure", handler: (*NBAStatistics).GetPlayerProjection, method: "GET", target: "/projection/player?playerId=1", fail: "ORDER BY r.game_date DESC, r.id DESC LIMIT", wantStatus: http.StatusInternalServerError},
+
+		// GetSimilarPlayers
+		{name: "similar", handler: (*NBAStatistics).GetSimilarPlayers, method: "GET", target: "/similar?playerId=1&k=2", wantStatus: http.StatusOK, want: []string{`"distance":`}},
+		{name: "similar without minutes", handler: (*NBAStatistics).GetSimilarPlayers, method: "GET", target: "/similar?playerId=5", wantStatus: http.StatusBadRequest, want: []string{"has not played any minutes"}},
+		{name: "similar invalid k", handler: (*NBAStatistics).GetSimilarPlayers, method: "GET", target: "/similar?playerId=1&k=0", wantStatus: http.StatusBadRequest, want: []string{"Invalid k"}},
+		{name: "similar unknown id", handler: (*NBAStatistics).GetSimilarPlayers, method: "GET", target: "/similar?playerId=99", wantStatus: http.StatusBadRequest},
+		{name: "similar db failure", handler: (*NBAStatistics).GetSimilarPlayers, method: "GET", target: "/similar?playerId=1", fail: "FROM player_totals", wantStatus: http.StatusInternalServerError},
+
+		// Search
+		{name: "search", handler: (*NBAStatistics).Search, method: "GET", target: "/players/search?q=stephen%20cury", wantStatus: http.StatusOK, want: []string{`[{"type":"player","id":3,"name":"Stephen Curry","teamId":2,"team":"Warriors","distance":1}]`}},
+		{name: "search with aggregates", handler: (*NBAStatistics).Search, method: "GET", target: "/players/search?q=lakers&aggregate=true", wantStatus: http.StatusOK, want: []string{`"type":"team"`, `"aggregate":{"id":1,"name":"Lakers","games":3`}},
+		{name: "search with aggregates as of", handler: (*NBAStatistics).Search, method: "GET", target: "/players/search?q=lakers&aggregate=true&asOf=2025-01-10", wantStatus: http.StatusOK, want: []string{`"aggregate":{"id":1,"name":"Lakers","games":1`}},
+		{name: "search invalid asOf", handler: (*NBAStatistics).Search, method: "GET", target: "/players/search?q=lakers&aggregate=true&asOf=2999-01-01", wantStatus: http.StatusBadRequest, want: []string{"asOf must be in the past"}},
+		{name: "search empty query", handler: (*NBAStatistics).Search, method: "GET", target: "/players/search?q=%20", wantStatus: http.StatusBadRequest, want: []string{"Invalid q"}},
+		{name: "search invalid limit", handler: (*NBAStatistics).Search, method: "GET", target: "/players/search?q=curry&limit=x", wantStatus: http.StatusBadRequest},
+		{name: "search aggregates db failure", handler: (*NBAStatistics).Search, method: "GET", target: "/players/search?q=curry&aggregate=true", fail: "FROM player_totals", wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newSeededDB()
+			nba := newTestNBAStatistics(t, db)
+			db.fail = test.fail
+
+			w := serve(nba, test.handler, test.method, test.target, test.body)
+			if w.Code != test.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, test.wantStatus, w.Body.String())
+			}
+			for _, want := range test.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("response does not contain %s: %s", want, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+// The records of both teams in a game split them by home and away, result and opponent. A record of one team may change
+// the result of the game, so it invalidates the cached splits of the players of the other team too.
+func TestGameSplits(t *testing.T) {
+	db := newSeededDB()
+	nba := newTestNBAStatistics(t, db)
+
+	if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", `{"id": 1, "points": 20, "minutes": 30, "date": "2025-02-03", "opponentId": 2, "home": false}`); w.Code != http.StatusCreated {
+		t.Fatalf("got status %d adding record: %s", w.Code, w.Body.String())
+	}
+	if w := serve(nba, (*NBAStatistics).GetPlayerSplits, "GET", "/aggregate/player/splits?playerId=1", ""); !strings.Contains(w.Body.String(), `"result":[{"split":"win","id":1,"name":"LeBron James","games":2`) {
+		t.Fatalf("unexpected result splits before the opponent's records: %s", w.Body.String())
+	}
+	for _, body := range []string{
+		`{"id": 3, "points": 15, "minutes": 30, "date": "2025-02-03", "opponentId": 1, "home": true}`,
+		`{"id": 4, "points": 12, "minutes": 30, "date": "2025-02-03", "opponentId": 1, "home": true}`,
+	} {
+		if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", body); w.Code != http.StatusCreated {
+			t.Fatalf("got status %d adding record: %s", w.Code, w.Body.String())
+		}
+	}
+
+	tests := []struct {
+		handler func(*NBAStatistics, http.ResponseWriter, *http.Request)
+		target  string
+		want    []string
+	}{
+		{(*NBAStatistics).GetPlayerSplits, "/aggregate/player/splits?playerId=1", []string{`{"split":"away","id":1,"name":"LeBron James","games":1,"points":20`, `{"split":"loss","id":1,"name":"LeBron James","games":1,"points":20`, `{"split":"win","id":1,"name":"LeBron James","games":1,"points":25`}},
+		{(*NBAStatistics).GetTeamSplits, "/aggregate/team/splits?teamId=2", []string{`"home":[{"split":"home","id":2,"name":"Warriors","games":1,"points":13.5`, `"result":[{"split":"win"`, `"opponent":[{"split":"Lakers","id":2,"name":"Warriors","games":1`}},
+	}
+	for _, test := range tests {
+		w := serve(nba, test.handler, "GET", test.target, "")
+		for _, want := range test.want {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s does not contain %s: %s", test.target, want, w.Body.String())
+			}
+		}
+	}
+}
+
+// A record of a game invalidates the cached standings, and its opponent's records count towards them
+func TestAddRecordInvalidatesStandings(t *testing.T) {
+	db := newSeededDB()
+	nba := newTestNBAStatistics(t, db)
+
+	if w := serve(nba, (*NBAStatistics).GetStandings, "GET", "/standings", ""); !strings.Contains(w.Body.String(), `"name":"Warriors","conference":"West","division":"Pacific","wins":0,"losses":1`) {
+		t.Fatalf("unexpected standings before the game: %s", w.Body.String())
+	}
+	for _, body := range []string{
+		`{"id": 3, "points": 31, "minutes": 30, "date": "2025-02-03", "opponentId": 1, "home": true}`,
+		`{"id": 1, "points": 24, "minutes": 30, "date": "2025-02-03", "opponentId": 2, "home": false}`,
+	} {
+		if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", body); w.Code != http.StatusCreated {
+			t.Fatalf("got status %d adding record: %s", w.Code, w.Body.String())
+		}
+	}
+	w := serve(nba, (*NBAStatistics).GetStandings, "GET", "/standings?division=Pacific", "")
+	for _, want := range []string{`"name":"Lakers","conference":"West","division":"Pacific","wins":1,"losses":1,"pct":0.5,"gamesBehind":0,`, `"away":{"wins":0,"losses":1},"lastTen":{"wins":1,"losses":1},"streak":"L1"`,
+		`"name":"Warriors","conference":"West","division":"Pacific","wins":1,"losses":1,"pct":0.5,"gamesBehind":0,"home":{"wins":1,"losses":0}`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("standings do not contain %s after the game: %s", want, w.Body.String())
+		}
+	}
+}
+
+// A record of a game updates the cached ratings and their history, and a team regresses to the mean at its first game
+// of a season
+func TestAddRecordUpdatesRatings(t *testing.T) {
+	db := newSeededDB()
+	nba := newTestNBAStatistics(t, db)
+
+	if w := serve(nba, (*NBAStatistics).GetRatings, "GET", "/ratings", ""); !strings.Contains(w.Body.String(), `[{"rank":1,"id":1,"name":"Lakers"`) {
+		t.Fatalf("unexpected ratings before the game: %s", w.Body.String())
+	}
+	// A game of the next season the Warriors win at home
+	for _, body := range []string{
+		`{"id": 3, "points": 30, "minutes": 30, "date": "2025-10-20", "opponentId": 1, "home": true}`,
+		`{"id": 1, "points": 20, "minutes": 30, "date": "2025-10-20", "opponentId": 2, "home": false}`,
+	} {
+		if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", body); w.Code != http.StatusCreated {
+			t.Fatalf("got status %d adding record: %s", w.Code, w.Body.String())
+		}
+	}
+
+	var ratings []TeamRating
+	if err := json.Unmarshal(serve(nba, (*NBAStatistics).GetRatings, "GET", "/ratings", "").Body.Bytes(), &ratings); err != nil {
+		t.Fatal(err)
+	}
+	var history []RatingChange
+	if err := json.Unmarshal(serve(nba, (*NBAStatistics).GetRatingHistory, "GET", "/ratings/history?teamId=1", "").Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("got %d rating changes of the Lakers, want 2: %+v", len(history), history)
+	}
+	if regressed := eloMean + (1-eloSeasonRegression)*(history[0].Rating-eloMean); math.Abs(history[1].RatingBefore-regressed) > 1e-9 {
+		t.Errorf("got rating %v before the first game of the season, want %v", history[1].RatingBefore, regressed)
+	}
+	if ratings[0].Name != "Warriors" || ratings[0].Games != 2 || history[1].Rating >= history[1].RatingBefore {
+		t.Errorf("ratings not updated by the game: %+v, history %+v", ratings, history)
+	}
+	var total float64
+	for _, rating := range ratings {
+		total += rating.Rating
+	}
+	if math.Abs(total-3*eloMean) > 1e-9 {
+		t.Errorf("ratings sum to %v, want %v", total, 3*eloMean)
+	}
+}
+
+// A new record invalidates the cached aggregates of the player and their team and updates the built leaderboards
+func TestAddRecordInvalidatesCache(t *testing.T) {
+	db := newSeededDB()
+	nba := newTestNBAStatistics(t, db)
+
+	for _, target := range []string{"/aggregate/player?playerId=3", "/aggregate/player?playerId=3&window=last5"} {
+		if w := serve(nba, (*NBAStatistics).GetPlayerAggregate, "GET", target, ""); !strings.Contains(w.Body.String(), `"points":35`) {
+			t.Fatalf("unexpected aggregate before the new record: %s", w.Body.String())
+		}
+	}
+	serve(nba, (*NBAStatistics).GetLeaders, "GET", "/leaders?stat=points&minGames=1&minMinutes=0", "")
+
+	if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", `{"id": 3, "points": 25, "minutes": 30, "date": "2025-01-13"}`); w.Code != http.StatusCreated {
+		t.Fatalf("got status %d adding record: %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		handler func(*NBAStatistics, http.ResponseWriter, *http.Request)
+		target  string
+		want    string
+	}{
+		{(*NBAStatistics).GetPlayerAggregate, "/aggregate/player?playerId=3", `"games":2,"points":30`},
+		{(*NBAStatistics).GetPlayerAggregate, "/aggregate/player?playerId=3&window=last5", `"games":2,"points":30`},
+		{(*NBAStatistics).GetTeamAggregate, "/aggregate/team?teamId=2", `"games":2,"points":26`},
+		{(*NBAStatistics).GetLeaders, "/leaders?stat=points&minGames=1&minMinutes=0", `[{"rank":1,"id":1,"name":"LeBron James"`},
+	}
+	for _, test := range tests {
+		if w := serve(nba, test.handler, "GET", test.target, ""); !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s does not contain %s after the new record: %s", test.target, test.want, w.Body.String())
+		}
+	}
+}
+
+// In stale-while-revalidate mode the leaderboards are updated with the new aggregate, not the stale one still served
+func TestAddRecordUpdatesLeadersWhileRevalidating(t *testing.T) {
+	db := newSeededDB()
+	nba, err := NewNBAStatistics(cache.NewMemoryCache(1000), db, Options{StaleWhileRevalidate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(nba, (*NBAStatistics).GetPlayerAggregate, "GET", "/aggregate/player?playerId=3", "")
+	serve(nba, (*NBAStatistics).GetLeaders, "GET", "/leaders?stat=points&minGames=1&minMinutes=0", "")
+
+	if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", `{"id": 3, "points": 25, "minutes": 30, "date": "2025-01-13"}`); w.Code != http.StatusCreated {
+		t.Fatalf("got status %d adding record: %s", w.Code, w.Body.String())
+	}
+
+	members, err := nba.cache.ZRevRangeWithScores(leadersCacheKey("players", "points"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	score := -1.0
+	for _, member := range members {
+		if member.Member == "3" {
+			score = member.Score
+		}
+	}
+	if score != 30 {
+		t.Errorf("points leaderboard score of player 3 = %v, expected the new average 30", score)
+	}
+}
+
+func TestLeadersExpireAndAreRebuilt(t *testing.T) {
+	db := newSeededDB()
+	nba, err := NewNBAStatistics(cache.NewMemoryCache(1000), db, Options{CacheTTLs: map[string]time.Duration{CacheAggregate: 10 * time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(nba, (*NBAStatistics).GetLeaders, "GET", "/leaders?stat=points", "")
+	time.Sleep(20 * time.Millisecond)
+	if _, err := nba.cache.Get(leadersBuiltKey("players")); err == nil {
+		t.Fatal("leaderboards did not expire after the aggregate TTL")
+	}
+
+	w := serve(nba, (*NBAStatistics).GetLeaders, "GET", "/leaders?stat=points&minGames=1&minMinutes=0&limit=1", "")
+	if !strings.Contains(w.Body.String(), `[{"rank":1,"id":3,"name":"Stephen Curry"`) {
+		t.Errorf("leaderboards were not rebuilt after expiring: %s", w.Body.String())
+	}
+}
+
+// A failed build leaves no partial leaderboards, and they are built by the next read
+func TestLeadersBuildFailureLeavesNoPartialBoards(t *testing.T) {
+	db := newSeededDB()
+	nba := newTestNBAStatistics(t, db)
+
+	db.fail = "FROM player_totals"
+	if w := serve(nba, (*NBAStatistics).GetLeaders, "GET", "/leaders?stat=points", ""); w.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d, expected the build to fail", w.Code)
+	}
+	if _, err := nba.cache.Get(leadersBuiltKey("players")); err == nil {
+		t.Error("leaderboards marked as built after a failed build")
+	}
+	for _, key := range []string{leadersCacheKey("players", "points"), leadersBuildingKey("players", "points")} {
+		if n, _ := nba.cache.ZCard(key); n != 0 {
+			t.Errorf("%s holds %d members after a failed build", key, n)
+		}
+	}
+
+	db.fail = ""
+	w := serve(nba, (*NBAStatistics).GetLeaders, "GET", "/leaders?stat=points&minGames=1&minMinutes=0&limit=1", "")
+	if !strings.Contains(w.Body.String(), `[{"rank":1,"id":3,"name":"Stephen Curry"`) {
+		t.Errorf("leaderboards were not built after the failure: %s", w.Body.String())
+	}
+}
+
+// The similarity vectors are updated by new records, so the similar players are found without reading every aggregate
+func TestSimilarityVectorsAreUpdatedOnWrite(t *testing.T) {
+	db := newSeededDB()
+	nba := newTestNBAStatistics(t, db)
+	serve(nba, (*NBAStatistics).GetSimilarPlayers, "GET", "/similar?playerId=1", "")
+
+	if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", `{"id": 4, "points": 30, "minutes": 30, "date": "2025-01-13"}`); w.Code != http.StatusCreated {
+		t.Fatalf("got status %d adding record: %s", w.Code, w.Body.String())
+	}
+	vectors, built := nba.loadSimilarity()
+	if !built || math.Abs(vectors.PerMinutes[4][0]-28.8) > 1e-9 {
+		t.Fatalf("similarity vector of player 4 = %v, expected 28.8 points per 36 minutes", vectors)
+	}
+
+	db.fail = "FROM player_totals"
+	w := serve(nba, (*NBAStatistics).GetSimilarPlayers, "GET", "/similar?playerId=1&k=1", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"distance":`) {
+		t.Errorf("got status %d, similar players not found from the stored vectors: %s", w.Code, w.Body.String())
+	}
+}
+
+// The vectors are updated under the lock across pods, after another pod holding it releases it
+func TestSimilarityVectorsAreUpdatedUnderTheLock(t *testing.T) {
+	db := newSeededDB()
+	nba := newTestNBAStatistics(t, db)
+	serve(nba, (*NBAStatistics).GetSimilarPlayers, "GET", "/similar?playerId=1", "")
+
+	nba.cache.SetNX(lockKey(similarityCacheKey()), "another pod", 100*time.Millisecond)
+	if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", `{"id": 4, "points": 30, "minutes": 30, "date": "2025-01-13"}`); w.Code != http.StatusCreated {
+		t.Fatalf("got status %d adding record: %s", w.Code, w.Body.String())
+	}
+	vectors, built := nba.loadSimilarity()
+	if !built || math.Abs(vectors.PerMinutes[4][0]-28.8) > 1e-9 {
+		t.Errorf("similarity vector of player 4 = %v, expected 28.8 points per 36 minutes once the lock is released", vectors)
+	}
+}
+
+func TestAddRecordDetectsEvents(t *testing.T) {
+	db := newSeededDB()
+	nba := newTestNBAStatistics(t, db)
+
+	body := `{"id": 3, "points": 42, "rebounds": 10, "assists": 11, "minutes": 40, "date": "2025-02-05"}`
+	if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", body); w.Code != http.StatusCreated {
+		t.Fatalf("got status %d adding record: %s", w.Code, w.Body.String())
+	}
+
+	w := serve(nba, (*NBAStatistics).GetEvents, "GET", "/events?playerId=3", "")
+	for _, want := range []string{`"type":"triple_double"`, `"type":"double_double"`, `"type":"40_points","value":42`, `"type":"career_high_points","value":42`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("events do not contain %s: %s", want, w.Body.String())
+		}
+	}
+}
+
+// A record dated before the others of the player reevaluates their career events
+func TestAddRecordBeforeOthersRechecksCareerEvents(t *testing.T) {
+	db := newSeededDB()
+	nba := newTestNBAStatistics(t, db)
+
+	for _, body := range []string{
+		`{"id": 3, "points": 40, "minutes": 40, "date": "2025-02-05"}`,
+		`{"id": 3, "points": 45, "minutes": 40, "date": "2025-01-05"}`,
+	} {
+		if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", body); w.Code != http.StatusCreated {
+			t.Fatalf("got status %d adding record: %s", w.Code, w.Body.String())
+		}
+	}
+
+	w := serve(nba, (*NBAStatistics).GetEvents, "GET", "/events?playerId=3", "")
+	if strings.Contains(w.Body.String(), `"type":"career_high_points"`) {
+		t.Errorf("the career high of 40 points is kept after the earlier record of 45 points: %s", w.Body.String())
+	}
+	for _, want := range []string{`"type":"40_points","value":40`, `"type":"40_points","value":45`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("events do not contain %s: %s", want, w.Body.String())
+		}
+	}
+}
+
+// The record is stored once its transaction commits, so a failure invalidating the cache does not fail the request
+func TestAddRecordSucceedsWhenInvalidationFails(t *testing.T) {
+	db := newSeededDB()
+	nba := newTestNBAStatistics(t, db)
+	serve(nba, (*NBAStatistics).GetLeaders, "GET", "/leaders?stat=points", "")
+
+	db.fail = "FROM player_totals"
+	if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", `{"id": 3, "points": 25, "minutes": 30}`); w.Code != http.StatusCreated {
+		t.Errorf("got status %d, expected the stored record to be reported as created: %s", w.Code, w.Body.String())
+	}
+}
+
+// A record whose events or totals cannot be stored is rolled back with everything it wrote, so it can be posted again
+func TestAddRecordRollsBack(t *testing.T) {
+	for _, fail := range []string{"INSERT INTO events", "INSERT INTO team_totals"} {
+		db := newSeededDB()
+		nba := newTestNBAStatistics(t, db)
+		records, events, totals := len(db.records), len(db.events), fmt.Sprint(db.totals)
+
+		db.fail = fail
+		body := `{"id": 3, "points": 42, "minutes": 40, "date": "2025-02-05"}`
+		if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", body); w.Code != http.StatusInternalServerError {
+			t.Fatalf("%s: got status %d, expected the failure to fail the request", fail, w.Code)
+		}
+		if len(db.records) != records || len(db.events) != events || fmt.Sprint(db.totals) != totals {
+			t.Errorf("%s: the record, its events or the player totals were kept after the failure", fail)
+		}
+	}
+}
+
+// Records stored before game dates were recorded count towards the aggregates of all records only
+func TestRecordsWithoutGameDate(t *testing.T) {
+	db := newSeededDB()
+	db.records = append(db.records, fakeRecord{id: 7, playerID: 5, stats: statLine(8, 9, 6, 1, 1, 2, 3, 30), createdAt: time.Now().Add(-time.Hour)})
+	db.backfillTotals("player_totals")
+	db.backfillTotals("team_totals")
+	nba := newTestNBAStatistics(t, db)
+
+	for _, test := range []struct {
+		handler func(*NBAStatistics, http.ResponseWriter, *http.Request)
+		target  string
+		want    string
+	}{
+		{(*NBAStatistics).GetPlayerAggregate, "/aggregate/player?playerId=5", `"games":1,`},
+		{(*NBAStatistics).GetTeamAggregate, "/aggregate/team?teamId=2", `"games":1,`},
+		{(*NBAStatistics).GetPlayerAggregate, "/aggregate/player?playerId=5&window=last10", `"games":0,`},
+		{(*NBAStatistics).GetPlayerAggregate, "/aggregate/player?playerId=5&asOf=" + time.Now().Format(time.DateOnly), `"games":0,`},
+		{(*NBAStatistics).GetPlayerSeries, "/series/player?playerId=5&stat=points", `[]`},
+		{(*NBAStatistics).GetPlayerSplits, "/aggregate/player/splits?playerId=5", `"month":[]`},
+	} {
+		if w := serve(nba, test.handler, "GET", test.target, ""); !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s: got status %d, expected %s: %s", test.target, w.Code, test.want, w.Body.String())
+		}
+	}
+	if _, err := BackfillEvents(db); err != nil {
+		t.Errorf("BackfillEvents() failed on a record without a game date: %v", err)
+	}
+}
+
+func TestAllAggregatesFromFreshView(t *testing.T) {
+	db := newSeededDB()
+	nba := newTestNBAStatistics(t, db)
+	db.viewsLocked = true
+	if refreshed, err := RefreshViews(db); err != nil || refreshed || len(db.refreshes) != 0 {
+		t.Fatalf("RefreshViews() = %v, %v while another instance refreshes the views", refreshed, err)
+	}
+	db.viewsLocked = false
+	if refreshed, err := RefreshViews(db); err != nil || !refreshed {
+		t.Fatalf("RefreshViews() = %v, %v", refreshed, err)
+	}
+
+	db.fail = "FROM team_totals"
+	w := serve(nba, (*NBAStatistics).GetAllTeamsAggregate, "GET", "/aggregate/teams?maxStaleness=1m", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `{"id":1,"name":"Lakers","games":3`) {
+		t.Errorf("got status %d, teams not served from the view: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRebuildTotals(t *testing.T) {
+	db := newSeededDB()
+	want := fmt.Sprint(db.totals)
+	delete(db.totals["player_totals"], 1)
+	db.totals["team_totals"][2] = fakeTotals{games: 7, records: 1}
+
+	if err := RebuildTotals(db); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(db.statements[0], "LOCK TABLE records") || !strings.Contains(db.statements[len(db.statements)-1], "COUNT(DISTINCT r.game_date), COUNT(r.id)") {
+		t.Errorf("the totals should be rebuilt with the records locked, counting team games by date: %v", db.statements)
+	}
+	if got := fmt.Sprint(db.totals); got != want {
+		t.Errorf("rebuilt totals = %s, want %s", got, want)
+	}
+
+	delete(db.totals["player_totals"], 1)
+	broken := fmt.Sprint(db.totals)
+	db.fail = "INSERT INTO team_totals"
+	if err := RebuildTotals(db); err == nil || fmt.Sprint(db.totals) != broken {
+		t.Errorf("RebuildTotals() = %v, expected the failed rebuild to be rolled back", err)
+	}
+}
@@ -0,0 +1,214 @@
+package nba
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	_ "github.com/lib/pq"
+	"github.com/pressly/goose/v3"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/cache"
+	"github.com/ShimonMoldawskiy/NBAStatistics/db"
+)
+
+// testPostgresURL returns the database of the integration test: NBA_TEST_POSTGRES_URL if set, e.g. a throwaway local
+// PostgreSQL, or else with NBA_TEST_EMBEDDED_POSTGRES set a PostgreSQL started by the test itself, without Docker.
+// The embedded server is stopped with the test; its binaries are downloaded once and cached in ~/.embedded-postgres-go.
+func testPostgresURL(t *testing.T) string {
+	if connString := os.Getenv("NBA_TEST_POSTGRES_URL"); connString != "" {
+		return connString
+	}
+	if os.Getenv("NBA_TEST_EMBEDDED_POSTGRES") == "" {
+		t.Skip("neither NBA_TEST_POSTGRES_URL nor NBA_TEST_EMBEDDED_POSTGRES is set")
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	config := embeddedpostgres.DefaultConfig().Port(uint32(port)).Database("nba_test").RuntimePath(t.TempDir()).Logger(io.Discard)
+	postgres := embeddedpostgres.NewDatabase(config)
+	if err := postgres.Start(); err != nil {
+		t.Fatalf("Unable to start embedded PostgreSQL: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := postgres.Stop(); err != nil {
+			t.Errorf("Unable to stop embedded PostgreSQL: %v", err)
+		}
+	})
+	return config.GetConnectionURL() + "?sslmode=disable"
+}
+
+// TestPostgresIntegration runs the handlers against a real database, migrated first, see testPostgresURL.
+// It only adds records, so it can be repeated.
+func TestPostgresIntegration(t *testing.T) {
+	connString := testPostgresURL(t)
+
+	dbMigration, err := goose.OpenDBWithDriver("postgres", connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = goose.Up(dbMigration, "../migrations")
+	dbMigration.Close()
+	if err != nil {
+		t.Fatalf("Unable to migrate the database: %v", err)
+	}
+
+	database, err := db.NewPostgresDatabase(context.Background(), connString, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	testIntegration(t, database)
+}
+
+// TestSQLiteIntegration runs the handlers against a SQLite database file, migrated first
+func TestSQLiteIntegration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nba.db")
+	if err := db.MigrateSQLite(path, "../migrations/sqlite"); err != nil {
+		t.Fatalf("Unable to migrate the database: %v", err)
+	}
+
+	database, err := db.NewSQLiteDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	testIntegration(t, database)
+}
+
+// testIntegration adds records of players and games through the handlers, checks the aggregates from the totals
+// against the ones from the records, and serves every statistic
+func testIntegration(t *testing.T, database Database) {
+	nba, err := NewNBAStatistics(cache.NewMemoryCache(1000), database, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aggregate := func() AggregatedRecord {
+		w := serve(nba, (*NBAStatistics).GetPlayerAggregate, "GET", "/aggregate/player?playerId=1", "")
+		var record AggregatedRecord
+		if err := json.Unmarshal(w.Body.Bytes(), &record); err != nil {
+			t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+		}
+		return record
+	}
+	before := aggregate()
+
+	yesterday := time.Now().AddDate(0, 0, -1).Format(time.DateOnly)
+	if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", `{"id": 1, "points": 31, "rebounds": 10, "assists": 10, "minutes": 37, "date": "`+yesterday+`"}`); w.Code != http.StatusCreated {
+		t.Fatalf("got status %d adding record: %s", w.Code, w.Body.String())
+	}
+	after := aggregate()
+	if after.Games != before.Games+1 || after.TripleDoubles != before.TripleDoubles+1 {
+		t.Errorf("aggregate not updated by the new record: before %+v, after %+v", before, after)
+	}
+
+	// Records added at once for a team on a new date count as a single game of the team
+	team := nba.players[1].Team
+	date := time.Now().AddDate(-2, 0, -rand.Intn(365)).Format(time.DateOnly)
+	var wg sync.WaitGroup
+	for _, playerID := range []int{1, 1, 2, 2, 1, 2} {
+		wg.Add(1)
+		go func(playerID int) {
+			defer wg.Done()
+			if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", fmt.Sprintf(`{"id": %d, "points": 10, "minutes": 20, "date": "%s"}`, playerID, date)); w.Code != http.StatusCreated {
+				t.Errorf("got status %d adding record: %s", w.Code, w.Body.String())
+			}
+		}(playerID)
+	}
+	wg.Wait()
+	fromTotals, err := nba.queryAggregate(team, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromRecords, err := nba.queryAggregate(team, Filter{AsOf: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromTotals.Games != fromRecords.Games {
+		t.Errorf("concurrent records counted %d team games in the totals, want %d", fromTotals.Games, fromRecords.Games)
+	}
+
+	// The records of both teams in a game share it, and a game of the teams with home and away swapped is a conflict
+	gameDate := time.Now().AddDate(-4, 0, -rand.Intn(365)).Format(time.DateOnly)
+	for _, test := range []struct {
+		body       string
+		wantStatus int
+	}{
+		{fmt.Sprintf(`{"id": 1, "points": 30, "minutes": 36, "date": "%s", "opponentId": 2, "home": true}`, gameDate), http.StatusCreated},
+		{fmt.Sprintf(`{"id": 3, "points": 28, "minutes": 35, "date": "%s", "opponentId": 1, "home": false}`, gameDate), http.StatusCreated},
+		{fmt.Sprintf(`{"id": 4, "points": 12, "minutes": 30, "date": "%s", "opponentId": 1, "home": true}`, gameDate), http.StatusConflict},
+	} {
+		if w := serve(nba, (*NBAStatistics).AddRecord, "POST", "/record", test.body); w.Code != test.wantStatus {
+			t.Errorf("got status %d adding record %s, want %d: %s", w.Code, test.body, test.wantStatus, w.Body.String())
+		}
+	}
+
+	if err := RebuildTotals(database); err != nil {
+		t.Fatal(err)
+	}
+	for _, object := range []AggregatedObject{nba.players[1], team} {
+		fromTotals, err := nba.queryAggregate(object, Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromRecords, err := nba.queryAggregate(object, Filter{AsOf: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fromTotals.Games != fromRecords.Games || math.Abs(fromTotals.Points-fromRecords.Points) > 1e-9 || math.Abs(fromTotals.Minutes-fromRecords.Minutes) > 1e-9 ||
+			fromTotals.DoubleDoubles != fromRecords.DoubleDoubles || fromTotals.TripleDoubles != fromRecords.TripleDoubles {
+			t.Errorf("rebuilt totals %+v differ from the records %+v", fromTotals, fromRecords)
+		}
+	}
+
+	if _, err := RefreshViews(database); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		handler func(*NBAStatistics, http.ResponseWriter, *http.Request)
+		target  string
+	}{
+		{(*NBAStatistics).GetPlayerAggregate, "/aggregate/player?playerId=1&window=last30days&asOf=" + yesterday},
+		{(*NBAStatistics).GetTeamAggregate, "/aggregate/team?teamId=1&window=last10"},
+		{(*NBAStatistics).GetPlayerSplits, "/aggregate/player/splits?playerId=1"},
+		{(*NBAStatistics).GetTeamSplits, "/aggregate/team/splits?teamId=1&window=last5"},
+		{(*NBAStatistics).GetAllPlayersAggregate, "/aggregate/players?maxStaleness=1m"},
+		{(*NBAStatistics).GetAllTeamsAggregate, "/aggregate/teams"},
+		{(*NBAStatistics).GetPlayerSeries, "/series/player?playerId=1&stat=points"},
+		{(*NBAStatistics).GetTeamSeries, "/series/team?teamId=1&stat=assists"},
+		{(*NBAStatistics).GetLeaders, "/leaders?stat=points&minGames=1&minMinutes=0"},
+		{(*NBAStatistics).Compare, "/compare?players=1,2"},
+		{(*NBAStatistics).Compare, "/compare?teams=1,2&window=last10"},
+		{(*NBAStatistics).GetEvents, "/events?playerId=1&type=triple_double"},
+		{(*NBAStatistics).GetPlayerFantasy, "/fantasy/player?profile=standard&playerId=1"},
+		{(*NBAStatistics).GetFantasyLeaders, "/fantasy/leaders?profile=standard&type=teams"},
+		{(*NBAStatistics).GetPlayerProjection, "/projection/player?playerId=1"},
+		{(*NBAStatistics).GetSimilarPlayers, "/similar?playerId=1"},
+		{(*NBAStatistics).Search, "/players/search?q=lebron&aggregate=true"},
+		{(*NBAStatistics).GetStandings, "/standings?conference=West"},
+		{(*NBAStatistics).GetRatings, "/ratings"},
+		{(*NBAStatistics).GetRatingHistory, "/ratings/history?teamId=1"},
+		{(*NBAStatistics).PredictGame, "/ratings/predict?home=2&away=1"},
+	} {
+		if w := serve(nba, test.handler, "GET", test.target, ""); w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d: %s", test.target, w.Code, w.Body.String())
+		}
+	}
+}
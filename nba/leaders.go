@@ -0,0 +1,304 @@
+package nba
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/common"
+)
+
+// Qualifiers applied to player leaderboards unless overridden by the request,
+// mirroring the NBA rule that leaders must have played enough games and minutes
+const (
+	defaultLeadersLimit      = 10
+	defaultLeadersMinGames   = 10
+	defaultLeadersMinMinutes = 15.0
+)
+
+type LeaderRecord struct {
+	Rank  int     `json:"rank"`
+	ID    int     `json:"id"`
+	Name  string  `json:"name"`
+	Games int     `json:"games"`
+	Value float64 `json:"value"`
+}
+
+// leadersCacheKey returns the key of the leaderboard sorted set. The leaderboards of a kind expire together
+// after the aggregate TTL and are rebuilt on the next read, bounding how long a missed update is served.
+func leadersCacheKey(kind, stat string) string {
+	return versionedKey(fmt.Sprintf("leaders_%s_%s", kind, stat))
+}
+
+// leadersBuiltKey returns the key telling that the leaderboards of the kind are complete.
+// It is set only once all of them are in place, and expires before them.
+func leadersBuiltKey(kind string) string {
+	return leadersCacheKey(kind, "built")
+}
+
+func leadersBuildingKey(kind, stat string) string {
+	return leadersCacheKey(kind, stat) + "_building"
+}
+
+// leaderObjects returns the players or teams ranked by a leaderboard of the given kind
+func (nba *NBAStatistics) leaderObjects(kind string) (map[int]AggregatedObject, bool) {
+	objects := make(map[int]AggregatedObject)
+	switch kind {
+	case "players":
+		for id, player := range nba.players {
+			objects[id] = player
+		}
+	case "teams":
+		for id, team := range nba.teams {
+			objects[id] = team
+		}
+	default:
+		return nil, false
+	}
+	return objects, true
+}
+
+// ensureLeaders builds the leaderboards of the given kind if they are not complete.
+// The build is coalesced like a cache fill, so a single pod builds them while the others wait.
+func (nba *NBAStatistics) ensureLeaders(kind string, objects map[int]AggregatedObject) error {
+	if _, err := nba.cache.Get(leadersBuiltKey(kind)); err == nil {
+		return nil
+	}
+	_, err := nba.fill(leadersBuiltKey(kind), nba.cacheTTL(CacheAggregate, false), func() ([]byte, error) {
+		if err := nba.buildLeaders(kind, objects); err != nil {
+			for _, stat := range AggregatedStats {
+				nba.cache.Del(leadersBuildingKey(kind, stat))
+			}
+			return nil, err
+		}
+		return []byte("1"), nil
+	})
+	return err
+}
+
+// buildLeaders fills the leaderboard sorted sets of the given kind from the aggregates.
+// They are filled aside and then replace the current ones, so readers never see a partial leaderboard.
+// The aggregates are read from the DB, as the cache may serve stale values while they are revalidated.
+func (nba *NBAStatistics) buildLeaders(kind string, objects map[int]AggregatedObject) error {
+	for _, stat := range AggregatedStats {
+		if err := nba.cache.Del(leadersBuildingKey(kind, stat)); err != nil {
+			return err
+		}
+	}
+	for id, object := range objects {
+		aggregate, err := nba.queryAggregate(object, Filter{})
+		if err != nil {
+			return err
+		}
+		for _, stat := range AggregatedStats {
+			value, _ := aggregate.Stat(stat)
+			if err := nba.cache.ZAdd(leadersBuildingKey(kind, stat), value, strconv.Itoa(id)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The leaderboards outlive the key telling they are built, so they are not read once expired
+	ttl := nba.cacheTTL(CacheAggregate, false)
+	if ttl > 0 {
+		ttl += cacheLockTTL
+	}
+	for _, stat := range AggregatedStats {
+		if err := nba.cache.Rename(leadersBuildingKey(kind, stat), leadersCacheKey(kind, stat), ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (nba *NBAStatistics) updateLeaders(kind string, id int, aggregate *AggregatedRecord) error {
+	for _, stat := range AggregatedStats {
+		value, _ := aggregate.Stat(stat)
+		if err := nba.cache.ZAdd(leadersCacheKey(kind, stat), value, strconv.Itoa(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshLeaders updates the leaderboards with the new aggregate of a single player or team.
+// Leaderboards that have not been built yet are left to be built on the next read. If they are being built,
+// the build may have read the previous aggregate, so the update waits for it to complete.
+func (nba *NBAStatistics) refreshLeaders(kind string, id int, object AggregatedObject) error {
+	for deadline := time.Now().Add(cacheLockTTL); time.Now().Before(deadline); time.Sleep(cacheLockPollInterval) {
+		if _, err := nba.cache.Get(lockKey(leadersBuiltKey(kind))); err != nil {
+			break
+		}
+	}
+	if _, err := nba.cache.Get(leadersBuiltKey(kind)); err != nil {
+		return nil
+	}
+	aggregate, err := nba.queryAggregate(object, Filter{})
+	if err != nil {
+		return err
+	}
+	return nba.updateLeaders(kind, id, aggregate)
+}
+
+func (nba *NBAStatistics) scores(kind, stat string) (map[string]float64, error) {
+	members, err := nba.cache.ZRevRangeWithScores(leadersCacheKey(kind, stat))
+	if err != nil {
+		return nil, err
+	}
+	scores := make(map[string]float64, len(members))
+	for _, member := range members {
+		scores[member.Member] = member.Score
+	}
+	return scores, nil
+}
+
+// rankLeaders returns the players or teams ranked by the stat, with their games and minutes, from the leaderboards.
+// The leaderboards are built on first use, afterwards they are updated by AddRecord.
+func (nba *NBAStatistics) rankLeaders(kind, stat string, objects map[int]AggregatedObject) ([]common.ScoredMember, map[string]float64, map[string]float64, error) {
+	if err := nba.ensureLeaders(kind, objects); err != nil {
+		return nil, nil, nil, err
+	}
+
+	ranked, err := nba.cache.ZRevRangeWithScores(leadersCacheKey(kind, stat))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	games, err := nba.scores(kind, "games")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	minutes, err := nba.scores(kind, "minutes")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ranked, games, minutes, nil
+}
+
+// rankAggregates ranks the players or teams like rankLeaders, from their aggregates that pass the filter, e.g. as of
+// a past time, as the leaderboards only hold the current aggregates. The aggregates are read one by one, from the cache
+// if they are there and caching is enabled for the endpoint.
+func (nba *NBAStatistics) rankAggregates(stat string, objects map[int]AggregatedObject, f Filter) ([]common.ScoredMember, map[string]float64, map[string]float64, error) {
+	var ranked []common.ScoredMember
+	games, minutes := make(map[string]float64), make(map[string]float64)
+	for id, object := range objects {
+		aggregate, err := nba.getAggregateRecord(EndpointLeaders, object, f)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		member := strconv.Itoa(id)
+		value, _ := aggregate.Stat(stat)
+		ranked = append(ranked, common.ScoredMember{Member: member, Score: value})
+		games[member], minutes[member] = float64(aggregate.Games), aggregate.Minutes
+	}
+
+	// Order ties by descending member, as the leaderboards do
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Member > ranked[j].Member
+	})
+	return ranked, games, minutes, nil
+}
+
+func (nba *NBAStatistics) GetLeaders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	stat := query.Get("stat")
+	if _, ok := (&AggregatedRecord{}).Stat(stat); !ok {
+		http.Error(w, "Invalid stat", http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("season") != "" {
+		http.Error(w, "season filter is not supported: records are not linked to seasons", http.StatusBadRequest)
+		return
+	}
+
+	kind := query.Get("type")
+	if kind == "" {
+		kind = "players"
+	}
+	objects, ok := nba.leaderObjects(kind)
+	if !ok {
+		http.Error(w, "Invalid type", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		limit      = defaultLeadersLimit
+		minGames   = defaultLeadersMinGames
+		minMinutes = defaultLeadersMinMinutes
+		err        error
+	)
+	if kind == "teams" {
+		minGames, minMinutes = 0, 0
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if minGamesStr := query.Get("minGames"); minGamesStr != "" {
+		if minGames, err = strconv.Atoi(minGamesStr); err != nil || minGames < 0 {
+			http.Error(w, "Invalid minGames", http.StatusBadRequest)
+			return
+		}
+	}
+	if minMinutesStr := query.Get("minMinutes"); minMinutesStr != "" {
+		if minMinutes, err = strconv.ParseFloat(minMinutesStr, 64); err != nil || minMinutes < 0 {
+			http.Error(w, "Invalid minMinutes", http.StatusBadRequest)
+			return
+		}
+	}
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		ranked         []common.ScoredMember
+		games, minutes map[string]float64
+	)
+	if asOf.IsZero() && nba.cacheEnabled(EndpointLeaders) {
+		ranked, games, minutes, err = nba.rankLeaders(kind, stat, objects)
+	} else {
+		ranked, games, minutes, err = nba.rankAggregates(stat, objects, Filter{AsOf: asOf})
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	records := []LeaderRecord{}
+	for _, member := range ranked {
+		if len(records) == limit {
+			break
+		}
+		id, err := strconv.Atoi(member.Member)
+		if err != nil {
+			continue
+		}
+		object, exists := objects[id]
+		if !exists || games[member.Member] < float64(minGames) || minutes[member.Member] < minMinutes {
+			continue
+		}
+		records = append(records, LeaderRecord{
+			Rank:  len(records) + 1,
+			ID:    id,
+			Name:  object.NewAggregatedRecord().Name,
+			Games: int(games[member.Member]),
+			Value: member.Score,
+		})
+	}
+
+	resultJSON, _ := json.Marshal(records)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultJSON)
+}
@@ -2,34 +2,65 @@ package nba
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
+
+	"golang.org/x/sync/singleflight"
 
 	"github.com/ShimonMoldawskiy/NBAStatistics/common"
 )
 
 type Cache interface {
 	Get(key string) (string, error)
-	Set(key string, value interface{}) error
+	// Set stores the value of the key, expiring after the given duration or never if it is 0
+	Set(key string, value interface{}, expiration time.Duration) error
 	Del(key string) error
+	// SetNX sets the key only if it does not exist, reporting whether it was set
+	SetNX(key string, value interface{}, expiration time.Duration) (bool, error)
+	// CompareAndDelete deletes the key only if it holds the value, so a lock is only released by its owner
+	CompareAndDelete(key, value string) error
+	// Rename moves the value or sorted set of the key to newKey, expiring after the given duration or never if it is 0.
+	// It does nothing if the key does not exist.
+	Rename(key, newKey string, expiration time.Duration) error
+	// Expire sets the key to expire after the given duration
+	Expire(key string, expiration time.Duration) error
+	ZAdd(key string, score float64, member string) error
+	ZCard(key string) (int64, error)
+	ZRevRangeWithScores(key string) ([]common.ScoredMember, error)
 	Close()
 }
 
 type Database interface {
 	Exec(query string, args ...interface{}) error
 	Query(query string, args ...interface{}) (common.Rows, error)
+	InTx(fn func(tx common.Tx) error) error
 	Close()
 }
 
+type Options struct {
+	// StaleWhileRevalidate serves the previous value of an invalidated key while it is recomputed
+	StaleWhileRevalidate bool
+	// CacheTTLs are the TTLs of the cached values per cache kind, DefaultCacheTTLs if not set
+	CacheTTLs map[string]time.Duration
+	// CacheDisabled are the endpoints, see CacheEndpoints, whose values are always computed from the DB
+	CacheDisabled map[string]bool
+}
+
 type NBAStatistics struct {
-	cache   Cache
-	db      Database
-	teams   map[int]Team
-	players map[int]Player
+	cache    Cache
+	db       Database
+	options  Options
+	flight   singleflight.Group
+	teams    map[int]Team
+	players  map[int]Player
+	profiles map[string]FantasyProfile
 }
 
-func NewNBAStatistics(cache Cache, db Database) (*NBAStatistics, error) {
+func NewNBAStatistics(cache Cache, db Database, options Options) (*NBAStatistics, error) {
 	teams, err := GetTeams(db)
 	if err != nil {
 		return nil, err
@@ -38,14 +69,67 @@ func NewNBAStatistics(cache Cache, db Database) (*NBAStatistics, error) {
 	if err != nil {
 		return nil, err
 	}
+	profiles, err := GetFantasyProfiles(db)
+	if err != nil {
+		return nil, err
+	}
 	return &NBAStatistics{
-		cache:   cache,
-		db:      db,
-		teams:   teams,
-		players: players,
+		cache:    cache,
+		db:       db,
+		options:  options,
+		teams:    teams,
+		players:  players,
+		profiles: profiles,
 	}, nil
 }
 
+// invalidate drops the cached data of the player and their team after a new record,
+// and updates the leaderboards and similarity vectors they are part of.
+// A record of a game against an opponent may change its result, which splits the records of both teams and their players
+// and makes up the standings and ratings.
+func (nba *NBAStatistics) invalidate(player Player, opponentID int) error {
+	split := []AggregatedObject{player, player.Team}
+	if opponentID != 0 {
+		split = []AggregatedObject{player.Team, nba.teams[opponentID]}
+		for _, other := range nba.players {
+			if other.Team.ID == player.Team.ID || other.Team.ID == opponentID {
+				split = append(split, other)
+			}
+		}
+	}
+	if opponentID != 0 {
+		for _, key := range []string{standingsCacheKey(), ratingsCacheKey()} {
+			if err := nba.invalidateKey(CacheGames, key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, window := range Windows {
+		f := Filter{Window: window}
+		for _, key := range []string{player.CacheKey(f), player.Team.CacheKey(f)} {
+			if err := nba.invalidateKey(CacheAggregate, key); err != nil {
+				return err
+			}
+		}
+		for _, object := range split {
+			if err := nba.invalidateKey(CacheSplits, splitsCacheKey(object, f)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := nba.invalidateFantasy(player); err != nil {
+		return err
+	}
+
+	if err := nba.refreshLeaders("players", player.ID, player); err != nil {
+		return err
+	}
+	if err := nba.refreshLeaders("teams", player.Team.ID, player.Team); err != nil {
+		return err
+	}
+	return nba.refreshSimilarity(player)
+}
+
 func (nba *NBAStatistics) AddRecord(w http.ResponseWriter, r *http.Request) {
 	// Create and validate Record
 	record, err := NewRecord(r.Body)
@@ -68,56 +152,109 @@ func (nba *NBAStatistics) AddRecord(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if record.OpponentID != 0 {
+		if _, exists := nba.teams[record.OpponentID]; !exists {
+			http.Error(w, fmt.Sprintf("team with ID %d does not exist", record.OpponentID), http.StatusBadRequest)
+			return
+		}
+		if record.OpponentID == player.Team.ID {
+			http.Error(w, "opponentId must differ from the team of the player", http.StatusBadRequest)
+			return
+		}
+	}
 
-	// Insert record into db
-	err = record.saveToDB(nba.db)
+	// Insert record into db, detecting double-doubles, career highs and other milestones
+	err = record.saveToDB(nba.db, player.Team.ID)
+	if errors.Is(err, errGameConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Invalidate cache
-	if err = nba.cache.Del(player.CacheKey()); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-	if err = nba.cache.Del(player.Team.CacheKey()); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Invalidate cache and update leaderboards. The record is already stored, so a failure is only logged:
+	// the cached values and leaderboards it missed expire after their TTL.
+	if err = nba.invalidate(player, record.OpponentID); err != nil {
+		log.Printf("Unable to invalidate the cache of player %d: %v\n", player.ID, err)
 	}
 
 	w.WriteHeader(http.StatusCreated)
 }
 
-func (nba *NBAStatistics) getAggregateData(a AggregatedObject) ([]byte, error) {
-	// Check cache first
-	cachedResult, err := nba.cache.Get(a.CacheKey())
-	if err == nil {
-		return []byte(cachedResult), nil
+// parseFilter reads the aggregate filter from the request query parameters
+func parseFilter(r *http.Request) (Filter, error) {
+	window, err := ParseWindow(r.URL.Query().Get("window"))
+	if err != nil {
+		return Filter{}, err
+	}
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		return Filter{}, err
+	}
+	return Filter{Window: window, AsOf: asOf}, nil
+}
+
+// parseAsOf reads the optional asOf query parameter, which must be in the past, returning zero if it is not set.
+// The current date means up to now, the current minute.
+func parseAsOf(r *http.Request) (time.Time, error) {
+	asOfStr := r.URL.Query().Get("asOf")
+	if asOfStr == "" {
+		return time.Time{}, nil
+	}
+	asOf, err := ParseAsOf(asOfStr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := time.Now()
+	if !asOf.Before(now) {
+		if _, err := time.Parse(time.DateOnly, asOfStr); err != nil || asOf.Sub(now) >= 24*time.Hour {
+			return time.Time{}, fmt.Errorf("asOf must be in the past")
+		}
+		asOf = now.Truncate(time.Minute)
 	}
+	return asOf, nil
+}
 
-	// Query db for aggregate data
+// queryAggregate computes the aggregate from the DB, bypassing the cache
+func (nba *NBAStatistics) queryAggregate(a AggregatedObject, f Filter) (*AggregatedRecord, error) {
 	var aggregate *AggregatedRecord = a.NewAggregatedRecord()
-	queryResult, err := nba.db.Query(a.DBQuery())
+	query := a.DBQuery(f)
+	queryResult, err := nba.db.Query(query.SQL, query.Args...)
 	if err != nil {
-		return nil, fmt.Errorf("cannot get data for %s", a.CacheKey())
+		return nil, fmt.Errorf("cannot get data for %s", a.CacheKey(f))
 	}
 	defer queryResult.Close()
 	if queryResult.Next() {
-		if err = queryResult.Scan(
-			&aggregate.Points, &aggregate.Rebounds, &aggregate.Assists, &aggregate.Steals, &aggregate.Blocks,
-			&aggregate.Turnovers, &aggregate.Fouls, &aggregate.Minutes); err != nil {
+		if err = queryResult.Scan(aggregateFields(aggregate)...); err != nil {
 			return nil, err
 		}
 	}
+	return aggregate, queryResult.Err()
+}
+
+func (nba *NBAStatistics) getAggregateData(endpoint string, a AggregatedObject, f Filter) ([]byte, error) {
+	return nba.cached(endpoint, CacheAggregate, a.CacheKey(f), f.immutable(), func() ([]byte, error) {
+		aggregate, err := nba.queryAggregate(a, f)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(*aggregate)
+	})
+}
 
-	result, err := json.Marshal(*aggregate)
+func (nba *NBAStatistics) getAggregateRecord(endpoint string, a AggregatedObject, f Filter) (*AggregatedRecord, error) {
+	result, err := nba.getAggregateData(endpoint, a, f)
 	if err != nil {
 		return nil, err
 	}
 
-	// Put the result to cache
-	err = nba.cache.Set(a.CacheKey(), result)
-
-	return result, err
+	var record AggregatedRecord
+	if err := json.Unmarshal(result, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
 }
 
 func (nba *NBAStatistics) GetPlayerAggregate(w http.ResponseWriter, r *http.Request) {
@@ -137,7 +274,13 @@ func (nba *NBAStatistics) GetPlayerAggregate(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	result, err := nba.getAggregateData(player)
+	f, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := nba.getAggregateData(EndpointPlayerAggregate, player, f)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -165,7 +308,13 @@ func (nba *NBAStatistics) GetTeamAggregate(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	result, err := nba.getAggregateData(team)
+	f, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := nba.getAggregateData(EndpointTeamAggregate, team, f)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -177,21 +326,42 @@ func (nba *NBAStatistics) GetTeamAggregate(w http.ResponseWriter, r *http.Reques
 }
 
 func (nba *NBAStatistics) GetAllPlayersAggregate(w http.ResponseWriter, r *http.Request) {
-	var records []AggregatedRecord
+	f, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	for _, player := range nba.players {
-		result, err := nba.getAggregateData(player)
+	maxStaleness, err := parseMaxStaleness(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Serve from the materialized view if it is fresh enough, otherwise fall back to live aggregates
+	if maxStaleness > 0 && f.isAll() {
+		records, fresh, err := nba.getViewAggregates("players", maxStaleness)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if fresh {
+			resultJSON, _ := json.Marshal(records)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(resultJSON)
+			return
+		}
+	}
+
+	var records []AggregatedRecord
 
-		var record AggregatedRecord
-		if err := json.Unmarshal(result, &record); err != nil {
+	for _, player := range nba.players {
+		record, err := nba.getAggregateRecord(EndpointPlayersAggregate, player, f)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		records = append(records, record)
+		records = append(records, *record)
 	}
 
 	resultJSON, _ := json.Marshal(records)
@@ -200,21 +370,42 @@ func (nba *NBAStatistics) GetAllPlayersAggregate(w http.ResponseWriter, r *http.
 }
 
 func (nba *NBAStatistics) GetAllTeamsAggregate(w http.ResponseWriter, r *http.Request) {
-	var records []AggregatedRecord
+	f, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	for _, team := range nba.teams {
-		result, err := nba.getAggregateData(team)
+	maxStaleness, err := parseMaxStaleness(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Serve from the materialized view if it is fresh enough, otherwise fall back to live aggregates
+	if maxStaleness > 0 && f.isAll() {
+		records, fresh, err := nba.getViewAggregates("teams", maxStaleness)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if fresh {
+			resultJSON, _ := json.Marshal(records)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(resultJSON)
+			return
+		}
+	}
+
+	var records []AggregatedRecord
 
-		var record AggregatedRecord
-		if err := json.Unmarshal(result, &record); err != nil {
+	for _, team := range nba.teams {
+		record, err := nba.getAggregateRecord(EndpointTeamsAggregate, team, f)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		records = append(records, record)
+		records = append(records, *record)
 	}
 
 	resultJSON, _ := json.Marshal(records)
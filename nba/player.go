@@ -44,10 +44,34 @@ func (p Player) NewAggregatedRecord() *AggregatedRecord {
 	return &AggregatedRecord{ID: p.ID, Name: p.Name}
 }
 
-func (p Player) CacheKey() string {
-	return fmt.Sprintf("player_%d", p.ID)
+func (p Player) CacheKey(f Filter) string {
+	return versionedKey(fmt.Sprintf("player_%d", p.ID) + f.cacheKeySuffix())
 }
 
-func (p Player) DBQuery() string {
-	return fmt.Sprintf(`SELECT AVG(r.points) AS points, AVG(r.rebounds) AS rebounds, AVG(r.assists) AS assists, AVG(r.steals) AS steals, AVG(r.blocks) AS blocks, AVG(r.turnovers) AS turnovers, AVG(r.fouls) AS fouls, AVG(r.minutes) AS minutes FROM records r WHERE r.player_id=%d;`, p.ID)
+func (p Player) recordsQuery(f Filter, args *queryArgs) string {
+	source := `SELECT r.* FROM records r WHERE r.player_id=` + args.add(p.ID) + f.conditions(args)
+	if games := f.Window.lastGames(); games > 0 {
+		source += ` ORDER BY r.game_date DESC, r.id DESC LIMIT ` + args.add(games)
+	}
+	return source
+}
+
+func (p Player) DBQuery(f Filter) Query {
+	if f.isAll() {
+		return totalsQuery("player_totals", "player_id", p.ID)
+	}
+	var args queryArgs
+	return aggregateQuery(playerGamesCount, p.recordsQuery(f, &args), args)
+}
+
+func (p Player) SeriesQuery(stat string, games int, f Filter) Query {
+	var args queryArgs
+	source := p.recordsQuery(f, &args)
+	return Query{fmt.Sprintf(`SELECT r.game_date, r.%[1]s::float8 AS value, AVG(r.%[1]s) OVER (ORDER BY r.game_date, r.id ROWS BETWEEN %[2]s::int PRECEDING AND CURRENT ROW)::float8 AS average FROM (%[3]s) r WHERE r.game_date IS NOT NULL ORDER BY r.game_date, r.id;`, stat, args.add(games-1), source),
+		args}
+}
+
+func (p Player) SplitQuery(dimension string, f Filter) Query {
+	var args queryArgs
+	return splitQuery(dimension, playerGamesCount, p.recordsQuery(f, &args), args)
 }
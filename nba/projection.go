@@ -0,0 +1,181 @@
+package nba
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The projection weighs the recent games exponentially, halving the weight every projectionHalfLife games,
+// and adjusts the stat line to the minutes trend over the last projectionTrendGames games
+const (
+	projectionGames      = 30
+	projectionHalfLife   = 5.0
+	projectionTrendGames = 10
+	projectionZ          = 1.96 // 95% interval
+	maxMinutes           = 48.0
+)
+
+type ProjectedStat struct {
+	Value float64 `json:"value"`
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+}
+
+type Projection struct {
+	ID    int                      `json:"id"`
+	Name  string                   `json:"name"`
+	Games int                      `json:"games"`
+	Stats map[string]ProjectedStat `json:"stats"`
+}
+
+// ewma is an exponentially weighted moving average and variance
+type ewma struct {
+	alpha, mean, variance float64
+	started               bool
+}
+
+func (e *ewma) add(x float64) {
+	if !e.started {
+		e.mean, e.started = x, true
+		return
+	}
+	diff := x - e.mean
+	increment := e.alpha * diff
+	e.mean += increment
+	e.variance = (1 - e.alpha) * (e.variance + diff*increment)
+}
+
+// trend returns the least squares slope of the values per game
+func trend(values []float64) float64 {
+	n := float64(len(values))
+	if n < 2 {
+		return 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i, y := range values {
+		x := float64(i)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	return (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+}
+
+// project forecasts the next game from the per game values of each record statistic, oldest first
+func project(values map[string][]float64) map[string]ProjectedStat {
+	alpha := 1 - math.Pow(0.5, 1/projectionHalfLife)
+	averages := make(map[string]*ewma)
+	for _, stat := range RecordStats {
+		averages[stat] = &ewma{alpha: alpha}
+		for _, x := range values[stat] {
+			averages[stat].add(x)
+		}
+	}
+
+	minutes := values["minutes"]
+	if len(minutes) > projectionTrendGames {
+		minutes = minutes[len(minutes)-projectionTrendGames:]
+	}
+	projectedMinutes := math.Min(math.Max(averages["minutes"].mean+trend(minutes), 0), maxMinutes)
+	scale := 1.0
+	if averages["minutes"].mean > 0 {
+		scale = projectedMinutes / averages["minutes"].mean
+	}
+
+	stats := make(map[string]ProjectedStat)
+	for _, stat := range RecordStats {
+		value, deviation := averages[stat].mean*scale, math.Sqrt(averages[stat].variance)*scale
+		if stat == "minutes" {
+			value, deviation = projectedMinutes, math.Sqrt(averages[stat].variance)
+		}
+		projected := ProjectedStat{Value: value, Low: math.Max(value-projectionZ*deviation, 0), High: value + projectionZ*deviation}
+		if stat == "minutes" {
+			projected.High = math.Min(projected.High, maxMinutes)
+		}
+		stats[stat] = projected
+	}
+	return stats
+}
+
+// getRecentValues returns the per game values of the player's most recent records that pass the filter, oldest first
+func (nba *NBAStatistics) getRecentValues(player Player, f Filter) (map[string][]float64, int, error) {
+	args := queryArgs{player.ID}
+	conditions := f.conditions(&args)
+	rows, err := nba.db.Query(fmt.Sprintf("SELECT r.%s::float8 FROM records r WHERE r.player_id=$1 AND r.game_date IS NOT NULL%s ORDER BY r.game_date DESC, r.id DESC LIMIT %s", strings.Join(RecordStats, "::float8, r."), conditions, args.add(projectionGames)),
+		args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot get records for %s", player.CacheKey(f))
+	}
+	defer rows.Close()
+
+	var games [][]float64
+	for rows.Next() {
+		game := make([]float64, len(RecordStats))
+		dest := make([]interface{}, len(RecordStats))
+		for i := range game {
+			dest[i] = &game[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, 0, err
+		}
+		games = append(games, game)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	values := make(map[string][]float64)
+	for i := len(games) - 1; i >= 0; i-- {
+		for j, stat := range RecordStats {
+			values[stat] = append(values[stat], games[i][j])
+		}
+	}
+	return values, len(games), nil
+}
+
+func (nba *NBAStatistics) GetPlayerProjection(w http.ResponseWriter, r *http.Request) {
+	playerIDStr := r.URL.Query().Get("playerId")
+	playerID, err := strconv.Atoi(playerIDStr)
+	if err != nil {
+		http.Error(w, "Invalid playerId", http.StatusBadRequest)
+		return
+	}
+
+	player, exists := nba.players[playerID]
+	if !exists {
+		http.Error(w, fmt.Sprintf("player with ID %d does not exist", playerID), http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("opponent") != "" {
+		http.Error(w, "opponent adjustment is not supported: records are not linked to opponents", http.StatusBadRequest)
+		return
+	}
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	values, games, err := nba.getRecentValues(player, Filter{AsOf: asOf})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if games == 0 {
+		http.Error(w, fmt.Sprintf("player with ID %d has no records to project from", playerID), http.StatusBadRequest)
+		return
+	}
+
+	projection := Projection{ID: player.ID, Name: player.Name, Games: games, Stats: project(values)}
+
+	resultJSON, _ := json.Marshal(projection)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultJSON)
+}
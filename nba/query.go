@@ -0,0 +1,20 @@
+package nba
+
+import "fmt"
+
+// Query is an SQL statement with the values of its $n placeholders.
+// The values are never embedded in the SQL, so the statement text only depends on the shape of the query
+// and the statements prepared by the database can be reused across objects and filters.
+type Query struct {
+	SQL  string
+	Args []interface{}
+}
+
+// queryArgs collects the values of a query being built
+type queryArgs []interface{}
+
+// add appends the value and returns its placeholder
+func (args *queryArgs) add(value interface{}) string {
+	*args = append(*args, value)
+	return fmt.Sprintf("$%d", len(*args))
+}
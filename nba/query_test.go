@@ -0,0 +1,213 @@
+package nba
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var placeholderPattern = regexp.MustCompile(`\$(\d+)`)
+
+// checkPlaceholders verifies that the placeholders of the query are numbered from $1 without gaps and match its arguments
+func checkPlaceholders(t *testing.T, query Query) {
+	t.Helper()
+	used := make(map[int]bool)
+	for _, match := range placeholderPattern.FindAllStringSubmatch(query.SQL, -1) {
+		n, _ := strconv.Atoi(match[1])
+		used[n] = true
+	}
+	if len(used) != len(query.Args) {
+		t.Fatalf("query uses %d placeholders but has %d arguments: %s", len(used), len(query.Args), query.SQL)
+	}
+	for n := 1; n <= len(query.Args); n++ {
+		if !used[n] {
+			t.Fatalf("placeholder $%d is not used: %s", n, query.SQL)
+		}
+	}
+}
+
+func TestAggregateQueries(t *testing.T) {
+	team := Team{ID: 7, Name: "Lakers"}
+	player := Player{ID: 23, Name: "LeBron James", Team: team}
+	asOf := time.Date(2025, 2, 10, 23, 59, 59, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		query    Query
+		contains []string
+		args     []interface{}
+	}{
+		{
+			name:     "player totals",
+			query:    player.DBQuery(Filter{}),
+			contains: []string{"FROM player_totals t WHERE t.player_id=$1"},
+			args:     []interface{}{23},
+		},
+		{
+			name:     "team totals",
+			query:    team.DBQuery(Filter{}),
+			contains: []string{"FROM team_totals t WHERE t.team_id=$1"},
+			args:     []interface{}{7},
+		},
+		{
+			name:     "player last games",
+			query:    player.DBQuery(Filter{Window: WindowLast5}),
+			contains: []string{"r.player_id=$1", "LIMIT $2"},
+			args:     []interface{}{23, 5},
+		},
+		{
+			name:     "player last days",
+			query:    player.DBQuery(Filter{Window: WindowLast30Days}),
+			contains: []string{"r.player_id=$1", "r.game_date > CURRENT_DATE - $2::int"},
+			args:     []interface{}{23, 30},
+		},
+		{
+			name:     "player as of",
+			query:    player.DBQuery(Filter{AsOf: asOf}),
+			contains: []string{"r.player_id=$1", "r.game_date <= $2::date", "r.created_at <= $3::timestamptz"},
+			args:     []interface{}{23, "2025-02-10", asOf},
+		},
+		{
+			name:     "player last days as of",
+			query:    player.DBQuery(Filter{Window: WindowLast30Days, AsOf: asOf}),
+			contains: []string{"r.game_date <= $2::date", "r.game_date > $2::date - $4::int"},
+			args:     []interface{}{23, "2025-02-10", asOf, 30},
+		},
+		{
+			name:     "team last games",
+			query:    team.DBQuery(Filter{Window: WindowLast10}),
+			contains: []string{"p.team_id=$1", "r.game_date IN (SELECT DISTINCT r.game_date FROM records r JOIN players p ON r.player_id = p.id WHERE p.team_id=$1", "LIMIT $2)"},
+			args:     []interface{}{7, 10},
+		},
+		{
+			name:     "player month splits",
+			query:    player.SplitQuery("month", Filter{Window: WindowLast5}),
+			contains: []string{"GROUP BY split", "r.player_id=$1", "LIMIT $2"},
+			args:     []interface{}{23, 5},
+		},
+		{
+			name:     "team rest splits",
+			query:    team.SplitQuery("rest", Filter{AsOf: asOf}),
+			contains: []string{"WITH s AS (SELECT r.* FROM records r JOIN players p ON r.player_id = p.id WHERE p.team_id=$1"},
+			args:     []interface{}{7, "2025-02-10", asOf},
+		},
+		{
+			name:     "player opponent splits",
+			query:    player.SplitQuery("opponent", Filter{Window: WindowLast10}),
+			contains: []string{"JOIN team_games g ON g.game_id = r.game_id AND g.team_id = p.team_id", "WHERE (SELECT t.name FROM teams t WHERE t.id = g.opponent_id) IS NOT NULL", "LIMIT $2"},
+			args:     []interface{}{23, 10},
+		},
+		{
+			name:     "head-to-head last days as of",
+			query:    headToHeadQuery([]int{7, 9}, Filter{Window: WindowLast30Days, AsOf: asOf}),
+			contains: []string{"g.team_id IN ($1, $2) AND g.opponent_id IN ($1, $2)", "g.game_date <= $3::date", "g.game_date > $3::date - $4::int"},
+			args:     []interface{}{7, 9, "2025-02-10", 30},
+		},
+		{
+			name:     "head-to-head last games",
+			query:    headToHeadQuery([]int{7, 9}, Filter{Window: WindowLast5}),
+			contains: []string{"ORDER BY g.game_date DESC, g.game_id DESC LIMIT $3"},
+			args:     []interface{}{7, 9, 5},
+		},
+		{
+			name:     "player series",
+			query:    player.SeriesQuery("points", 5, Filter{}),
+			contains: []string{"r.points::float8 AS value", "ROWS BETWEEN $2::int PRECEDING", "r.player_id=$1"},
+			args:     []interface{}{23, 4},
+		},
+		{
+			name:     "team series",
+			query:    team.SeriesQuery("rebounds", 3, Filter{}),
+			contains: []string{"SUM(r.rebounds)", "ROWS BETWEEN $2::int PRECEDING", "p.team_id=$1"},
+			args:     []interface{}{7, 2},
+		},
+		{
+			name:     "player series as of",
+			query:    player.SeriesQuery("points", 5, Filter{AsOf: asOf}),
+			contains: []string{"r.game_date <= $2::date", "r.created_at <= $3::timestamptz", "ROWS BETWEEN $4::int PRECEDING"},
+			args:     []interface{}{23, "2025-02-10", asOf, 4},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkPlaceholders(t, test.query)
+			for _, s := range test.contains {
+				if !strings.Contains(test.query.SQL, s) {
+					t.Errorf("query does not contain %q: %s", s, test.query.SQL)
+				}
+			}
+			if !reflect.DeepEqual(test.query.Args, test.args) {
+				t.Errorf("got arguments %v, want %v", test.query.Args, test.args)
+			}
+		})
+	}
+}
+
+// The SQL of a query depends on its shape only, so the prepared statements are shared by all objects
+func TestAggregateQueriesDoNotEmbedValues(t *testing.T) {
+	asOf := time.Date(2025, 2, 10, 12, 0, 0, 0, time.UTC)
+	for _, f := range []Filter{{}, {Window: WindowLast5}, {Window: WindowLast30Days}, {AsOf: asOf}, {Window: WindowLast10, AsOf: asOf}} {
+		for _, pair := range [][2]AggregatedObject{{Player{ID: 1}, Player{ID: 2}}, {Team{ID: 1}, Team{ID: 2}}} {
+			if a, b := pair[0].DBQuery(f), pair[1].DBQuery(f); a.SQL != b.SQL {
+				t.Errorf("aggregate SQL differs between IDs for filter %+v: %q, %q", f, a.SQL, b.SQL)
+			}
+			for _, dimension := range SplitDimensions {
+				if a, b := pair[0].SplitQuery(dimension, f), pair[1].SplitQuery(dimension, f); a.SQL != b.SQL {
+					t.Errorf("%s split SQL differs between IDs for filter %+v", dimension, f)
+				}
+			}
+		}
+	}
+	if a, b := (Player{ID: 1}).SeriesQuery("points", 5, Filter{}), (Player{ID: 2}).SeriesQuery("points", 10, Filter{}); a.SQL != b.SQL {
+		t.Errorf("series SQL differs between IDs and games: %q, %q", a.SQL, b.SQL)
+	}
+	if strings.Contains(Player{ID: 1}.DBQuery(Filter{AsOf: asOf}).SQL, "2025") {
+		t.Error("asOf is embedded in the SQL")
+	}
+}
+
+func TestUnknownSplitDimension(t *testing.T) {
+	if query := (Player{ID: 1}).SplitQuery("weekday", Filter{}); query.SQL != "" {
+		t.Errorf("got query %q for unknown dimension", query.SQL)
+	}
+}
+
+func TestFantasyQueries(t *testing.T) {
+	profile := FantasyProfile{
+		Name:              "standard",
+		Weights:           map[string]float64{"points": 1, "rebounds": 1.2, "assists": 1.5, "steals": 3, "blocks": 3, "turnovers": -1},
+		DoubleDoubleBonus: 1.5,
+		TripleDoubleBonus: 3,
+	}
+	// The weights of all record statistics in RecordStats order, then the triple-double and double-double bonuses
+	weights := []interface{}{1.0, 1.2, 1.5, 3.0, 3.0, -1.0, 0.0, 0.0, 3.0, 1.5}
+	asOf := time.Date(2025, 2, 10, 23, 59, 59, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		query Query
+		args  []interface{}
+	}{
+		{"player", profile.DBQuery(Player{ID: 23}, Filter{}), append(append([]interface{}{}, weights...), 23)},
+		{"team", profile.DBQuery(Team{ID: 7}, Filter{}), append(append([]interface{}{}, weights...), 7)},
+		{"player leaders", profile.LeadersDBQuery("players", Filter{}), weights},
+		{"team leaders", profile.LeadersDBQuery("teams", Filter{}), weights},
+		{"team leaders as of", profile.LeadersDBQuery("teams", Filter{AsOf: asOf}), append(append([]interface{}{}, weights...), "2025-02-10", asOf)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkPlaceholders(t, test.query)
+			if !strings.Contains(test.query.SQL, "r.points * $1::float8") {
+				t.Errorf("weights are not passed as arguments: %s", test.query.SQL)
+			}
+			if !reflect.DeepEqual(test.query.Args, test.args) {
+				t.Errorf("got arguments %v, want %v", test.query.Args, test.args)
+			}
+		})
+	}
+}
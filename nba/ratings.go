@@ -0,0 +1,287 @@
+package nba
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/common"
+)
+
+// The Elo ratings start at eloMean, move by up to about eloK points per game scaled by the margin of victory,
+// and give the home team eloHomeAdvantage points. At its first game of a season a team regresses eloSeasonRegression
+// of the way to the mean. Seasons start in eloSeasonStartMonth.
+const (
+	eloMean             = 1500.0
+	eloK                = 20.0
+	eloHomeAdvantage    = 100.0
+	eloSeasonRegression = 0.25
+	eloSeasonStartMonth = time.August
+)
+
+// ratingsLock is the advisory lock taken while the rating history is rewritten, so a single pod rewrites it at a time
+const ratingsLock = 7532
+
+type TeamRating struct {
+	Rank   int     `json:"rank"`
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Rating float64 `json:"rating"`
+	Games  int     `json:"games"`
+}
+
+type RatingChange struct {
+	GameID       int     `json:"gameId"`
+	Date         string  `json:"date"`
+	OpponentID   int     `json:"opponentId"`
+	RatingBefore float64 `json:"ratingBefore"`
+	Rating       float64 `json:"rating"`
+}
+
+type Prediction struct {
+	Home TeamRating `json:"home"`
+	Away TeamRating `json:"away"`
+	// HomeWinProbability is the probability that the home team wins, given its home advantage
+	HomeWinProbability float64 `json:"homeWinProbability"`
+}
+
+func ratingsCacheKey() string {
+	return versionedKey("ratings")
+}
+
+// ratingsGamesQuery returns the decided games in game order, once per game from the side of the home team
+const ratingsGamesQuery = "SELECT g.game_id, g.game_date, g.team_id, g.points, g.opponent_id, g.opponent_points FROM team_games g WHERE g.home AND g.result IS NOT NULL ORDER BY g.game_date, g.game_id"
+
+// season returns the year the season of the date started in
+func season(date time.Time) int {
+	if date.Month() < eloSeasonStartMonth {
+		return date.Year() - 1
+	}
+	return date.Year()
+}
+
+// winProbability returns the expected score of a team rated ratingDiff points above its opponent, home advantage included
+func winProbability(ratingDiff float64) float64 {
+	return 1 / (1 + math.Pow(10, -ratingDiff/400))
+}
+
+// marginMultiplier scales the rating change by the margin of victory, damped when the favourite wins,
+// as the favourites win by larger margins
+func marginMultiplier(margin int, winnerRatingDiff float64) float64 {
+	return math.Log(math.Abs(float64(margin))+1) * 2.2 / (winnerRatingDiff*0.001 + 2.2)
+}
+
+// computeRatings rates the teams from the results of all games in game order and rewrites the rating history
+// in the same transaction, returning the current ratings ranked
+func (nba *NBAStatistics) computeRatings() ([]TeamRating, error) {
+	ratings, games, seasons := make(map[int]float64), make(map[int]int), make(map[int]int)
+	for id := range nba.teams {
+		ratings[id] = eloMean
+	}
+
+	err := nba.db.InTx(func(tx common.Tx) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock($1)", ratingsLock); err != nil {
+			return err
+		}
+		rows, err := tx.Query(ratingsGamesQuery)
+		if err != nil {
+			return err
+		}
+		var history [][]interface{}
+		for rows.Next() {
+			var (
+				gameID, homeID, awayID, homePoints, awayPoints int
+				gameDate                                       time.Time
+			)
+			if err := rows.Scan(&gameID, &gameDate, &homeID, &homePoints, &awayID, &awayPoints); err != nil {
+				rows.Close()
+				return err
+			}
+			for _, id := range []int{homeID, awayID} {
+				if _, exists := ratings[id]; !exists {
+					ratings[id] = eloMean
+				}
+				if s := season(gameDate); games[id] > 0 && seasons[id] != s {
+					ratings[id] = eloMean + (1-eloSeasonRegression)*(ratings[id]-eloMean)
+				}
+				seasons[id] = season(gameDate)
+				games[id]++
+			}
+
+			homeBefore, awayBefore := ratings[homeID], ratings[awayID]
+			diff := homeBefore + eloHomeAdvantage - awayBefore
+			result, margin := 0.0, homePoints-awayPoints
+			if margin > 0 {
+				result = 1
+			} else {
+				diff = -diff
+			}
+			change := eloK * marginMultiplier(margin, diff) * (result - winProbability(homeBefore+eloHomeAdvantage-awayBefore))
+			ratings[homeID], ratings[awayID] = homeBefore+change, awayBefore-change
+
+			date := gameDate.Format(time.DateOnly)
+			history = append(history, []interface{}{gameID, homeID, date, awayID, homeBefore, ratings[homeID]},
+				[]interface{}{gameID, awayID, date, homeID, awayBefore, ratings[awayID]})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if err := tx.Exec("DELETE FROM team_ratings"); err != nil {
+			return err
+		}
+		for _, row := range history {
+			if err := tx.Exec("INSERT INTO team_ratings (game_id, team_id, game_date, opponent_id, rating_before, rating) VALUES ($1, $2, $3::date, $4, $5, $6)", row...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute the ratings: %w", err)
+	}
+
+	teamRatings := []TeamRating{}
+	for id, team := range nba.teams {
+		teamRatings = append(teamRatings, TeamRating{ID: id, Name: team.Name, Rating: ratings[id], Games: games[id]})
+	}
+	sort.Slice(teamRatings, func(i, j int) bool {
+		if teamRatings[i].Rating != teamRatings[j].Rating {
+			return teamRatings[i].Rating > teamRatings[j].Rating
+		}
+		return teamRatings[i].ID < teamRatings[j].ID
+	})
+	for i := range teamRatings {
+		teamRatings[i].Rank = i + 1
+	}
+	return teamRatings, nil
+}
+
+// getRatings returns the current ratings of all teams, ranked. They are cached, and recomputed with the rating history
+// after a record of a game.
+func (nba *NBAStatistics) getRatings() ([]TeamRating, error) {
+	result, err := nba.cached(EndpointRatings, CacheGames, ratingsCacheKey(), false, func() ([]byte, error) {
+		ratings, err := nba.computeRatings()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(ratings)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ratings []TeamRating
+	if err := json.Unmarshal(result, &ratings); err != nil {
+		return nil, err
+	}
+	return ratings, nil
+}
+
+func (nba *NBAStatistics) GetRatings(w http.ResponseWriter, r *http.Request) {
+	ratings, err := nba.getRatings()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resultJSON, _ := json.Marshal(ratings)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultJSON)
+}
+
+// GetRatingHistory returns the rating of the team before and after each of its games, in game order
+func (nba *NBAStatistics) GetRatingHistory(w http.ResponseWriter, r *http.Request) {
+	teamID, err := strconv.Atoi(r.URL.Query().Get("teamId"))
+	if err != nil {
+		http.Error(w, "Invalid teamId", http.StatusBadRequest)
+		return
+	}
+	if _, exists := nba.teams[teamID]; !exists {
+		http.Error(w, fmt.Sprintf("team with ID %d does not exist", teamID), http.StatusBadRequest)
+		return
+	}
+
+	// The history is rewritten with the ratings, so it is up to date once they are
+	if _, err := nba.getRatings(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows, err := nba.db.Query("SELECT r.game_id, r.game_date, r.opponent_id, r.rating_before, r.rating FROM team_ratings r WHERE r.team_id=$1 ORDER BY r.game_date, r.game_id", teamID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot get the rating history of team %d", teamID), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	history := []RatingChange{}
+	for rows.Next() {
+		var (
+			change   RatingChange
+			gameDate time.Time
+		)
+		if err := rows.Scan(&change.GameID, &gameDate, &change.OpponentID, &change.RatingBefore, &change.Rating); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		change.Date = gameDate.Format(time.DateOnly)
+		history = append(history, change)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resultJSON, _ := json.Marshal(history)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultJSON)
+}
+
+// PredictGame returns the probability that the home team beats the away team from their current ratings
+func (nba *NBAStatistics) PredictGame(w http.ResponseWriter, r *http.Request) {
+	homeID, err := strconv.Atoi(r.URL.Query().Get("home"))
+	if err != nil {
+		http.Error(w, "Invalid home", http.StatusBadRequest)
+		return
+	}
+	awayID, err := strconv.Atoi(r.URL.Query().Get("away"))
+	if err != nil {
+		http.Error(w, "Invalid away", http.StatusBadRequest)
+		return
+	}
+	for _, id := range []int{homeID, awayID} {
+		if _, exists := nba.teams[id]; !exists {
+			http.Error(w, fmt.Sprintf("team with ID %d does not exist", id), http.StatusBadRequest)
+			return
+		}
+	}
+	if homeID == awayID {
+		http.Error(w, "home and away must be different teams", http.StatusBadRequest)
+		return
+	}
+
+	ratings, err := nba.getRatings()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var prediction Prediction
+	for _, rating := range ratings {
+		switch rating.ID {
+		case homeID:
+			prediction.Home = rating
+		case awayID:
+			prediction.Away = rating
+		}
+	}
+	prediction.HomeWinProbability = winProbability(prediction.Home.Rating + eloHomeAdvantage - prediction.Away.Rating)
+
+	resultJSON, _ := json.Marshal(prediction)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultJSON)
+}
@@ -2,8 +2,12 @@ package nba
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"time"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/common"
 )
 
 type Record struct {
@@ -16,8 +20,17 @@ type Record struct {
 	Turnovers int     `json:"turnovers"`
 	Fouls     int     `json:"fouls"`
 	Minutes   float64 `json:"minutes"`
+	Date      string  `json:"date,omitempty"`
+	// OpponentID and Home give the game of the record, against the opponent team at home or away; both are optional
+	OpponentID int   `json:"opponentId,omitempty"`
+	Home       *bool `json:"home,omitempty"`
+
+	recordID int
 }
 
+// errGameConflict is returned when the game of a record contradicts another game of one of its teams on the same date
+var errGameConflict = errors.New("conflicting game")
+
 func NewRecord(data io.ReadCloser) (*Record, error) {
 	var record Record
 	err := json.NewDecoder(data).Decode(&record)
@@ -34,13 +47,204 @@ func (record *Record) Validate() error {
 	if record.Minutes < 0 || record.Minutes > 48.0 {
 		return fmt.Errorf("minutes must be between 0 and 48")
 	}
+	if record.Date != "" {
+		date, err := time.Parse(time.DateOnly, record.Date)
+		if err != nil {
+			return fmt.Errorf("date must be in YYYY-MM-DD format")
+		}
+		if date.After(time.Now()) {
+			return fmt.Errorf("date cannot be in the future")
+		}
+	}
+	if (record.OpponentID == 0) != (record.Home == nil) {
+		return fmt.Errorf("opponentId and home must be given together")
+	}
 	if record.Points < 0 || record.Rebounds < 0 || record.Assists < 0 || record.Steals < 0 || record.Blocks < 0 || record.Turnovers < 0 {
 		return fmt.Errorf("statistics values cannot be negative")
 	}
 	return nil
 }
 
-func (record *Record) saveToDB(db Database) error {
-	return db.Exec("INSERT INTO records (player_id, points, rebounds, assists, steals, blocks, turnovers, fouls, minutes) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
-		record.ID, record.Points, record.Rebounds, record.Assists, record.Steals, record.Blocks, record.Turnovers, record.Fouls, record.Minutes)
+// stat returns the value of the counting statistic with the given JSON name
+func (record *Record) stat(name string) int {
+	switch name {
+	case "points":
+		return record.Points
+	case "rebounds":
+		return record.Rebounds
+	case "assists":
+		return record.Assists
+	case "steals":
+		return record.Steals
+	case "blocks":
+		return record.Blocks
+	case "turnovers":
+		return record.Turnovers
+	case "fouls":
+		return record.Fouls
+	}
+	return 0
+}
+
+// doubleDigits returns the number of double-double categories reaching 10
+func (record *Record) doubleDigits() int {
+	count := 0
+	for _, stat := range doubleDigitsStats {
+		if record.stat(stat) >= 10 {
+			count++
+		}
+	}
+	return count
+}
+
+// saveToDB stores the record, dated today unless the game date is given, with its game and the events it sets off,
+// and adds it to the running totals of the player and their team in the same transaction
+func (record *Record) saveToDB(db Database, teamID int) error {
+	return db.InTx(func(tx common.Tx) error {
+		if err := record.insert(tx); err != nil {
+			return err
+		}
+		if record.OpponentID != 0 {
+			if err := record.saveGame(tx, teamID); err != nil {
+				return err
+			}
+		}
+		if err := recordEvents(tx, record); err != nil {
+			return err
+		}
+
+		// Every record is a game of the player, but only the first record of the date is a game of the team
+		teamGames, err := record.isNewTeamGame(tx, teamID)
+		if err != nil {
+			return err
+		}
+		var doubleDoubles, tripleDoubles int
+		if record.doubleDigits() >= 2 {
+			doubleDoubles = 1
+		}
+		if record.doubleDigits() >= 3 {
+			tripleDoubles = 1
+		}
+		for _, totals := range []struct {
+			table, idColumn string
+			id, games       int
+		}{{"player_totals", "player_id", record.ID, 1}, {"team_totals", "team_id", teamID, teamGames}} {
+			if err := tx.Exec(fmt.Sprintf(`INSERT INTO %[1]s (%[2]s, games, records, points, rebounds, assists, steals, blocks, turnovers, fouls, minutes, double_doubles, triple_doubles) VALUES ($1, $12, 1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+				ON CONFLICT (%[2]s) DO UPDATE SET games = %[1]s.games + EXCLUDED.games, records = %[1]s.records + 1, points = %[1]s.points + EXCLUDED.points, rebounds = %[1]s.rebounds + EXCLUDED.rebounds,
+				assists = %[1]s.assists + EXCLUDED.assists, steals = %[1]s.steals + EXCLUDED.steals, blocks = %[1]s.blocks + EXCLUDED.blocks,
+				turnovers = %[1]s.turnovers + EXCLUDED.turnovers, fouls = %[1]s.fouls + EXCLUDED.fouls, minutes = %[1]s.minutes + EXCLUDED.minutes,
+				double_doubles = %[1]s.double_doubles + EXCLUDED.double_doubles, triple_doubles = %[1]s.triple_doubles + EXCLUDED.triple_doubles`, totals.table, totals.idColumn),
+				totals.id, record.Points, record.Rebounds, record.Assists, record.Steals, record.Blocks, record.Turnovers, record.Fouls, record.Minutes,
+				doubleDoubles, tripleDoubles, totals.games); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// isNewTeamGame returns 1 if the saved record is the first of its team on its date, and 0 otherwise.
+// The records of a team on a date are counted under a lock held until the end of the transaction, as concurrent
+// transactions do not see each other's records and would both count the game.
+func (record *Record) isNewTeamGame(tx common.Tx, teamID int) (int, error) {
+	if err := lockTeamDate(tx, teamID, record.Date); err != nil {
+		return 0, err
+	}
+	rows, err := tx.Query("SELECT COUNT(*) FROM records r JOIN players p ON r.player_id = p.id WHERE p.team_id=$1 AND r.game_date=$2::date AND r.id <> $3",
+		teamID, record.Date, record.recordID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var others int
+	if rows.Next() {
+		if err := rows.Scan(&others); err != nil {
+			return 0, err
+		}
+	}
+	if others > 0 {
+		return 0, rows.Err()
+	}
+	return 1, rows.Err()
+}
+
+// lockTeamDate takes the lock of the records and games of a team on a date, held until the end of the transaction
+func lockTeamDate(tx common.Tx, teamID int, date string) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock($1, $2::date - DATE '2000-01-01')", teamID, date)
+}
+
+// saveGame links the saved record to its game, stored by the first record of either team. A team plays a single game
+// on a date, so a game against another opponent, or with home and away swapped, is a conflict. The locks of both teams
+// are taken in team order, so concurrent records of the two teams do not deadlock.
+func (record *Record) saveGame(tx common.Tx, teamID int) error {
+	homeTeamID, awayTeamID := teamID, record.OpponentID
+	if !*record.Home {
+		homeTeamID, awayTeamID = awayTeamID, homeTeamID
+	}
+	for _, id := range []int{min(teamID, record.OpponentID), max(teamID, record.OpponentID)} {
+		if err := lockTeamDate(tx, id, record.Date); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.Query("SELECT COUNT(*) FROM games WHERE game_date=$1::date AND (home_team_id IN ($2, $3) OR away_team_id IN ($2, $3)) AND NOT (home_team_id=$2 AND away_team_id=$3)",
+		record.Date, homeTeamID, awayTeamID)
+	if err != nil {
+		return err
+	}
+	var conflicts int
+	if rows.Next() {
+		err = rows.Scan(&conflicts)
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if conflicts > 0 {
+		return fmt.Errorf("%w: a team of the game already has another game on %s", errGameConflict, record.Date)
+	}
+
+	rows, err = tx.Query(`INSERT INTO games (game_date, home_team_id, away_team_id) VALUES ($1::date, $2, $3)
+		ON CONFLICT (game_date, home_team_id, away_team_id) DO UPDATE SET game_date = EXCLUDED.game_date RETURNING id`, record.Date, homeTeamID, awayTeamID)
+	if err != nil {
+		return err
+	}
+	var gameID int
+	if rows.Next() {
+		err = rows.Scan(&gameID)
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return tx.Exec("UPDATE records SET game_id=$1 WHERE id=$2", gameID, record.recordID)
+}
+
+func (record *Record) insert(tx common.Tx) error {
+	var date interface{}
+	if record.Date != "" {
+		date = record.Date
+	}
+	rows, err := tx.Query("INSERT INTO records (player_id, points, rebounds, assists, steals, blocks, turnovers, fouls, minutes, game_date) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE($10::date, CURRENT_DATE)) RETURNING id, game_date",
+		record.ID, record.Points, record.Rebounds, record.Assists, record.Steals, record.Blocks, record.Turnovers, record.Fouls, record.Minutes, date)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var gameDate time.Time
+		if err := rows.Scan(&record.recordID, &gameDate); err != nil {
+			return err
+		}
+		record.Date = gameDate.Format(time.DateOnly)
+	}
+	return rows.Err()
 }
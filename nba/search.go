@@ -0,0 +1,175 @@
+package nba
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const defaultSearchLimit = 10
+
+type SearchResult struct {
+	Type      string            `json:"type"`
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	TeamID    int               `json:"teamId,omitempty"`
+	Team      string            `json:"team,omitempty"`
+	Distance  int               `json:"distance"`
+	Aggregate *AggregatedRecord `json:"aggregate,omitempty"`
+}
+
+// normalizeName lowercases the name and strips its accents
+func normalizeName(name string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	normalized, _, err := transform.String(t, name)
+	if err != nil {
+		normalized = name
+	}
+	return strings.ToLower(strings.TrimSpace(normalized))
+}
+
+func levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	previous, current := make([]int, len(t)+1), make([]int, len(t)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		current[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(t)]
+}
+
+// maxEdits is the number of typos tolerated in a query word
+func maxEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// matchName returns the number of edits needed to match every word of the query to a word of the name,
+// or a prefix of it, and false if the name does not match within the tolerated typos
+func matchName(query, name string) (int, bool) {
+	query, name = normalizeName(query), normalizeName(name)
+	if query == "" {
+		return 0, false
+	}
+	if strings.Contains(name, query) {
+		return 0, true
+	}
+
+	total := 0
+	nameWords := strings.Fields(name)
+	for _, queryWord := range strings.Fields(query) {
+		best := -1
+		for _, nameWord := range nameWords {
+			distance := levenshtein(queryWord, nameWord)
+			// Typed prefixes may be a letter shorter or longer than the matching part of the name word
+			queryLen, nameRunes := len([]rune(queryWord)), []rune(nameWord)
+			for n := max(queryLen-1, 1); n <= queryLen+1 && n < len(nameRunes); n++ {
+				distance = min(distance, levenshtein(queryWord, string(nameRunes[:n])))
+			}
+			if best < 0 || distance < best {
+				best = distance
+			}
+		}
+		if best < 0 || best > maxEdits(queryWord) {
+			return 0, false
+		}
+		total += best
+	}
+	return total, true
+}
+
+func (nba *NBAStatistics) search(query string) []SearchResult {
+	results := []SearchResult{}
+	for _, player := range nba.players {
+		if distance, ok := matchName(query, player.Name); ok {
+			results = append(results, SearchResult{Type: "player", ID: player.ID, Name: player.Name, TeamID: player.Team.ID, Team: player.Team.Name, Distance: distance})
+		}
+	}
+	for _, team := range nba.teams {
+		if distance, ok := matchName(query, team.Name); ok {
+			results = append(results, SearchResult{Type: "team", ID: team.ID, Name: team.Name, Distance: distance})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance != results[j].Distance {
+			return results[i].Distance < results[j].Distance
+		}
+		if results[i].Name != results[j].Name {
+			return results[i].Name < results[j].Name
+		}
+		return results[i].Type < results[j].Type
+	})
+	return results
+}
+
+func (nba *NBAStatistics) Search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := query.Get("q")
+	if normalizeName(q) == "" {
+		http.Error(w, "Invalid q", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	f, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := nba.search(q)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	// Include the aggregates inline if requested, restricted by the window and asOf
+	if query.Get("aggregate") == "true" {
+		for i, result := range results {
+			var object AggregatedObject = nba.teams[result.ID]
+			if result.Type == "player" {
+				object = nba.players[result.ID]
+			}
+			aggregate, err := nba.getAggregateRecord(EndpointSearch, object, f)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			results[i].Aggregate = aggregate
+		}
+	}
+
+	resultJSON, _ := json.Marshal(results)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultJSON)
+}
@@ -0,0 +1,113 @@
+package nba
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultSeriesGames = 5
+
+type SeriesPoint struct {
+	Date    string  `json:"date"`
+	Value   float64 `json:"value"`
+	Average float64 `json:"average"`
+}
+
+func (nba *NBAStatistics) getSeries(a AggregatedObject, stat string, games int, f Filter) ([]SeriesPoint, error) {
+	query := a.SeriesQuery(stat, games, f)
+	rows, err := nba.db.Query(query.SQL, query.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get %s series for %s", stat, a.CacheKey(f))
+	}
+	defer rows.Close()
+
+	series := []SeriesPoint{}
+	for rows.Next() {
+		var (
+			point SeriesPoint
+			date  time.Time
+		)
+		if err := rows.Scan(&date, &point.Value, &point.Average); err != nil {
+			return nil, err
+		}
+		point.Date = date.Format(time.DateOnly)
+		series = append(series, point)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
+
+// writeSeries responds with the rolling average series of the statistic requested for the object, up to asOf if given
+func (nba *NBAStatistics) writeSeries(w http.ResponseWriter, r *http.Request, a AggregatedObject) {
+	stat := r.URL.Query().Get("stat")
+	if !isRecordStat(stat) {
+		http.Error(w, "Invalid stat", http.StatusBadRequest)
+		return
+	}
+
+	games := defaultSeriesGames
+	if gamesStr := r.URL.Query().Get("games"); gamesStr != "" {
+		var err error
+		if games, err = strconv.Atoi(gamesStr); err != nil || games <= 0 {
+			http.Error(w, "Invalid games", http.StatusBadRequest)
+			return
+		}
+	}
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series, err := nba.getSeries(a, stat, games, Filter{AsOf: asOf})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resultJSON, _ := json.Marshal(series)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultJSON)
+}
+
+func (nba *NBAStatistics) GetPlayerSeries(w http.ResponseWriter, r *http.Request) {
+	playerIDStr := r.URL.Query().Get("playerId")
+	playerID, err := strconv.Atoi(playerIDStr)
+	if err != nil {
+		http.Error(w, "Invalid playerId", http.StatusBadRequest)
+		return
+	}
+
+	player, exists := nba.players[playerID]
+	if !exists {
+		http.Error(w, fmt.Sprintf("player with ID %d does not exist", playerID), http.StatusBadRequest)
+		return
+	}
+
+	nba.writeSeries(w, r, player)
+}
+
+func (nba *NBAStatistics) GetTeamSeries(w http.ResponseWriter, r *http.Request) {
+	teamIDStr := r.URL.Query().Get("teamId")
+	teamID, err := strconv.Atoi(teamIDStr)
+	if err != nil {
+		http.Error(w, "Invalid teamId", http.StatusBadRequest)
+		return
+	}
+
+	team, exists := nba.teams[teamID]
+	if !exists {
+		http.Error(w, fmt.Sprintf("team with ID %d does not exist", teamID), http.StatusBadRequest)
+		return
+	}
+
+	nba.writeSeries(w, r, team)
+}
@@ -0,0 +1,240 @@
+package nba
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const defaultSimilarK = 5
+
+// similarityStats are the per-36 minutes statistics the players are compared by
+var similarityStats = []string{"points", "rebounds", "assists", "steals", "blocks", "turnovers", "fouls"}
+
+type SimilarRecord struct {
+	ID       int     `json:"id"`
+	Name     string  `json:"name"`
+	Distance float64 `json:"distance"`
+}
+
+// per36 returns the statistics of the aggregate per 36 minutes played
+func per36(a *AggregatedRecord) []float64 {
+	vector := make([]float64, len(similarityStats))
+	for i, stat := range similarityStats {
+		value, _ := a.Stat(stat)
+		vector[i] = value * 36 / a.Minutes
+	}
+	return vector
+}
+
+// normalize scales every dimension of the vectors to zero mean and unit deviation
+func normalize(vectors map[int][]float64) {
+	for i := range similarityStats {
+		var sum, sumSquares float64
+		for _, vector := range vectors {
+			sum += vector[i]
+			sumSquares += vector[i] * vector[i]
+		}
+		n := float64(len(vectors))
+		mean := sum / n
+		deviation := math.Sqrt(math.Max(sumSquares/n-mean*mean, 0))
+		for _, vector := range vectors {
+			vector[i] -= mean
+			if deviation > 0 {
+				vector[i] /= deviation
+			}
+		}
+	}
+}
+
+func distance(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return math.Sqrt(sum)
+}
+
+// similarityVectors are the per-36 minutes vectors of the players who played, and the same vectors normalized
+type similarityVectors struct {
+	PerMinutes map[int][]float64 `json:"perMinutes"`
+	Normalized map[int][]float64 `json:"normalized"`
+}
+
+// similarityCacheKey returns the key of the similarity vectors. Like the leaderboards, they are updated by AddRecord,
+// and expire after the aggregate TTL to be rebuilt on the next read, bounding how long a lost update is served.
+func similarityCacheKey() string {
+	return versionedKey("similarity_vectors")
+}
+
+// encodeSimilarity normalizes the per-36 minutes vectors and encodes them with the normalized ones
+func encodeSimilarity(vectors *similarityVectors) ([]byte, error) {
+	vectors.Normalized = make(map[int][]float64, len(vectors.PerMinutes))
+	for id, vector := range vectors.PerMinutes {
+		vectors.Normalized[id] = append([]float64{}, vector...)
+	}
+	normalize(vectors.Normalized)
+	return json.Marshal(vectors)
+}
+
+// loadSimilarity returns the stored similarity vectors, and false if they have not been built
+func (nba *NBAStatistics) loadSimilarity() (*similarityVectors, bool) {
+	cachedResult, err := nba.cache.Get(similarityCacheKey())
+	if err != nil {
+		return nil, false
+	}
+	var vectors similarityVectors
+	if err := json.Unmarshal([]byte(cachedResult), &vectors); err != nil {
+		return nil, false
+	}
+	return &vectors, true
+}
+
+// computeSimilarity computes the encoded similarity vectors of all players from their aggregates in the DB
+func (nba *NBAStatistics) computeSimilarity() ([]byte, error) {
+	vectors := &similarityVectors{PerMinutes: make(map[int][]float64)}
+	for id, player := range nba.players {
+		aggregate, err := nba.queryAggregate(player, Filter{})
+		if err != nil {
+			return nil, err
+		}
+		if aggregate.Minutes > 0 {
+			vectors.PerMinutes[id] = per36(aggregate)
+		}
+	}
+	return encodeSimilarity(vectors)
+}
+
+// buildSimilarity computes the similarity vectors of all players from their aggregates in the DB and stores them,
+// unless caching is disabled for the endpoint. The build is coalesced like a cache fill, under the lock refreshSimilarity takes.
+func (nba *NBAStatistics) buildSimilarity() (*similarityVectors, error) {
+	var (
+		result []byte
+		err    error
+	)
+	if nba.cacheEnabled(EndpointSimilar) {
+		result, err = nba.fill(similarityCacheKey(), nba.cacheTTL(CacheAggregate, false), nba.computeSimilarity)
+	} else {
+		result, err = nba.computeSimilarity()
+	}
+	if err != nil {
+		return nil, err
+	}
+	var vectors similarityVectors
+	if err := json.Unmarshal(result, &vectors); err != nil {
+		return nil, err
+	}
+	return &vectors, nil
+}
+
+// refreshSimilarity updates the similarity vectors with the new aggregate of the player, normalizing them again.
+// Vectors that have not been built yet are left to be built on the next read. The vectors are read and written back
+// under a lock across pods, so concurrent updates are not lost; if the lock is not taken in time they are dropped instead.
+func (nba *NBAStatistics) refreshSimilarity(player Player) error {
+	locked, err := nba.withLock(similarityCacheKey(), func() error {
+		vectors, built := nba.loadSimilarity()
+		if !built {
+			return nil
+		}
+		aggregate, err := nba.queryAggregate(player, Filter{})
+		if err != nil {
+			return err
+		}
+		if aggregate.Minutes > 0 {
+			vectors.PerMinutes[player.ID] = per36(aggregate)
+		}
+		result, err := encodeSimilarity(vectors)
+		if err != nil {
+			return err
+		}
+		return nba.cache.Set(similarityCacheKey(), result, nba.cacheTTL(CacheAggregate, false))
+	})
+	if err == nil && !locked {
+		return nba.cache.Del(similarityCacheKey())
+	}
+	return err
+}
+
+// getSimilar returns the k players nearest to the given one from the stored normalized vectors
+func (nba *NBAStatistics) getSimilar(player Player, k int) ([]SimilarRecord, error) {
+	var (
+		vectors *similarityVectors
+		built   bool
+	)
+	if nba.cacheEnabled(EndpointSimilar) {
+		vectors, built = nba.loadSimilarity()
+	}
+	if !built {
+		var err error
+		if vectors, err = nba.buildSimilarity(); err != nil {
+			return nil, err
+		}
+	}
+	target, ok := vectors.Normalized[player.ID]
+	if !ok {
+		return []SimilarRecord{}, nil
+	}
+
+	similar := []SimilarRecord{}
+	for id, vector := range vectors.Normalized {
+		if id != player.ID {
+			similar = append(similar, SimilarRecord{ID: id, Name: nba.players[id].Name, Distance: distance(target, vector)})
+		}
+	}
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Distance == similar[j].Distance {
+			return similar[i].ID < similar[j].ID
+		}
+		return similar[i].Distance < similar[j].Distance
+	})
+	if len(similar) > k {
+		similar = similar[:k]
+	}
+	return similar, nil
+}
+
+func (nba *NBAStatistics) GetSimilarPlayers(w http.ResponseWriter, r *http.Request) {
+	playerIDStr := r.URL.Query().Get("playerId")
+	playerID, err := strconv.Atoi(playerIDStr)
+	if err != nil {
+		http.Error(w, "Invalid playerId", http.StatusBadRequest)
+		return
+	}
+
+	player, exists := nba.players[playerID]
+	if !exists {
+		http.Error(w, fmt.Sprintf("player with ID %d does not exist", playerID), http.StatusBadRequest)
+		return
+	}
+
+	k := defaultSimilarK
+	if kStr := r.URL.Query().Get("k"); kStr != "" {
+		if k, err = strconv.Atoi(kStr); err != nil || k <= 0 {
+			http.Error(w, "Invalid k", http.StatusBadRequest)
+			return
+		}
+	}
+
+	aggregate, err := nba.getAggregateRecord(EndpointSimilar, player, Filter{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if aggregate.Minutes == 0 {
+		http.Error(w, fmt.Sprintf("player with ID %d has not played any minutes", playerID), http.StatusBadRequest)
+		return
+	}
+
+	similar, err := nba.getSimilar(player, k)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resultJSON, _ := json.Marshal(similar)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultJSON)
+}
@@ -0,0 +1,115 @@
+package nba
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+type SplitRecord struct {
+	Split string `json:"split"`
+	AggregatedRecord
+}
+
+func splitsCacheKey(a AggregatedObject, f Filter) string {
+	return a.CacheKey(f) + "_splits"
+}
+
+func (nba *NBAStatistics) getSplits(a AggregatedObject, dimension string, f Filter) ([]SplitRecord, error) {
+	query := a.SplitQuery(dimension, f)
+	rows, err := nba.db.Query(query.SQL, query.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get %s splits for %s", dimension, a.CacheKey(f))
+	}
+	defer rows.Close()
+
+	splits := []SplitRecord{}
+	for rows.Next() {
+		split := SplitRecord{AggregatedRecord: *a.NewAggregatedRecord()}
+		if err := rows.Scan(append([]interface{}{&split.Split}, aggregateFields(&split.AggregatedRecord)...)...); err != nil {
+			return nil, err
+		}
+		splits = append(splits, split)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return splits, nil
+}
+
+// getSplitsData returns the splits of all dimensions as a JSON document keyed by dimension
+func (nba *NBAStatistics) getSplitsData(endpoint string, a AggregatedObject, f Filter) ([]byte, error) {
+	return nba.cached(endpoint, CacheSplits, splitsCacheKey(a, f), f.immutable(), func() ([]byte, error) {
+		document := make(map[string][]SplitRecord)
+		for _, dimension := range SplitDimensions {
+			var err error
+			if document[dimension], err = nba.getSplits(a, dimension, f); err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(document)
+	})
+}
+
+func (nba *NBAStatistics) GetPlayerSplits(w http.ResponseWriter, r *http.Request) {
+	playerIDStr := r.URL.Query().Get("playerId")
+	playerID, err := strconv.Atoi(playerIDStr)
+	if err != nil {
+		http.Error(w, "Invalid playerId", http.StatusBadRequest)
+		return
+	}
+
+	player, exists := nba.players[playerID]
+	if !exists {
+		http.Error(w, fmt.Sprintf("player with ID %d does not exist", playerID), http.StatusBadRequest)
+		return
+	}
+
+	f, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := nba.getSplitsData(EndpointPlayerSplits, player, f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func (nba *NBAStatistics) GetTeamSplits(w http.ResponseWriter, r *http.Request) {
+	teamIDStr := r.URL.Query().Get("teamId")
+	teamID, err := strconv.Atoi(teamIDStr)
+	if err != nil {
+		http.Error(w, "Invalid teamId", http.StatusBadRequest)
+		return
+	}
+
+	team, exists := nba.teams[teamID]
+	if !exists {
+		http.Error(w, fmt.Sprintf("team with ID %d does not exist", teamID), http.StatusBadRequest)
+		return
+	}
+
+	f, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := nba.getSplitsData(EndpointTeamSplits, team, f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
@@ -0,0 +1,152 @@
+package nba
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+const (
+	// cacheLockTTL bounds how long the other pods wait for the one recomputing a missing key
+	cacheLockTTL          = 5 * time.Second
+	cacheLockPollInterval = 50 * time.Millisecond
+)
+
+func staleKey(key string) string {
+	return key + "_stale"
+}
+
+func lockKey(key string) string {
+	return key + "_lock"
+}
+
+func newLockToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// cached returns the cached value of the key of the given cache kind, computing and caching it on a miss,
+// or computes it if caching is disabled for the endpoint.
+// In stale-while-revalidate mode the previous value of an invalidated key is served while it is recomputed in the background.
+// Immutable values are cached for immutableCacheTTL.
+func (nba *NBAStatistics) cached(endpoint, kind, key string, immutable bool, compute func() ([]byte, error)) ([]byte, error) {
+	if !nba.cacheEnabled(endpoint) {
+		return compute()
+	}
+
+	// Check cache first
+	cachedResult, err := nba.cache.Get(key)
+	if err == nil {
+		return []byte(cachedResult), nil
+	}
+
+	if nba.options.StaleWhileRevalidate {
+		if staleResult, err := nba.cache.Get(staleKey(key)); err == nil {
+			go func() {
+				if _, err := nba.fill(key, nba.cacheTTL(kind, immutable), compute); err != nil {
+					log.Printf("Unable to revalidate %s: %v\n", key, err)
+				}
+			}()
+			return []byte(staleResult), nil
+		}
+	}
+
+	return nba.fill(key, nba.cacheTTL(kind, immutable), compute)
+}
+
+// fill computes the value of the key and puts it to cache.
+// Concurrent misses of the key are coalesced into a single computation per pod.
+func (nba *NBAStatistics) fill(key string, ttl time.Duration, compute func() ([]byte, error)) ([]byte, error) {
+	result, err, _ := nba.flight.Do(key, func() (interface{}, error) {
+		return nba.fillLocked(key, ttl, compute)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]byte), nil
+}
+
+// fillLocked computes the value of the key under a short lock across pods,
+// so the pods that do not get the lock wait for the value instead of repeating the query
+func (nba *NBAStatistics) fillLocked(key string, ttl time.Duration, compute func() ([]byte, error)) ([]byte, error) {
+	// The lock holds a token of its owner, so a slow owner whose lock expired does not release the lock of the next one
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	locked, err := nba.cache.SetNX(lockKey(key), token, cacheLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		defer nba.cache.CompareAndDelete(lockKey(key), token)
+	} else {
+		// If the lock holder fails or is too slow, compute the value anyway once the lock expires
+		for deadline := time.Now().Add(cacheLockTTL); time.Now().Before(deadline); {
+			time.Sleep(cacheLockPollInterval)
+			if cachedResult, err := nba.cache.Get(key); err == nil {
+				return []byte(cachedResult), nil
+			}
+		}
+	}
+
+	result, err := compute()
+	if err != nil {
+		return nil, err
+	}
+
+	// Put the result to cache, the stale value is no longer needed
+	if err = nba.cache.Set(key, result, ttl); err != nil {
+		return result, err
+	}
+	if nba.options.StaleWhileRevalidate {
+		err = nba.cache.Del(staleKey(key))
+	}
+
+	return result, err
+}
+
+// withLock runs fn under the lock of the key across pods, the lock taken by fillLocked, waiting up to cacheLockTTL for it.
+// It reports false without running fn if the lock is not taken in time.
+func (nba *NBAStatistics) withLock(key string, fn func() error) (bool, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return false, err
+	}
+	for deadline := time.Now().Add(cacheLockTTL); ; time.Sleep(cacheLockPollInterval) {
+		locked, err := nba.cache.SetNX(lockKey(key), token, cacheLockTTL)
+		if err != nil {
+			return false, err
+		}
+		if locked {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			return false, nil
+		}
+	}
+	defer nba.cache.CompareAndDelete(lockKey(key), token)
+	return true, fn()
+}
+
+// invalidateKey drops the cached value of the key of the given cache kind.
+// In stale-while-revalidate mode it is kept as the stale value until the key is filled again, at most for staleTTL.
+func (nba *NBAStatistics) invalidateKey(kind, key string) error {
+	if nba.options.StaleWhileRevalidate {
+		return nba.cache.Rename(key, staleKey(key), nba.staleTTL(kind))
+	}
+	return nba.cache.Del(key)
+}
+
+// staleTTL bounds how long the stale value of a key of the kind is kept: the TTL of the kind,
+// or its default TTL if the values of the kind are kept until they are invalidated
+func (nba *NBAStatistics) staleTTL(kind string) time.Duration {
+	if ttl := nba.options.CacheTTLs[kind]; ttl > 0 {
+		return ttl
+	}
+	return DefaultCacheTTLs[kind]
+}
@@ -0,0 +1,178 @@
+package nba
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// standingsLastGames is the number of most recent games of the last games record
+const standingsLastGames = 10
+
+type WinLoss struct {
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+}
+
+func (wl *WinLoss) add(result string) {
+	if result == "W" {
+		wl.Wins++
+	} else {
+		wl.Losses++
+	}
+}
+
+type StandingRecord struct {
+	Rank       int    `json:"rank"`
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Conference string `json:"conference,omitempty"`
+	Division   string `json:"division,omitempty"`
+	WinLoss
+	Pct float64 `json:"pct"`
+	// GamesBehind is the number of games the team trails the first team of the standings by
+	GamesBehind float64 `json:"gamesBehind"`
+	Home        WinLoss `json:"home"`
+	Away        WinLoss `json:"away"`
+	LastTen     WinLoss `json:"lastTen"`
+	// Streak is the result of the last games in a row with their number, e.g. W3, empty before the first game
+	Streak string `json:"streak"`
+
+	results []string
+}
+
+func standingsCacheKey() string {
+	return versionedKey("standings")
+}
+
+// standingsQuery returns the results of the games, in game order, from the side of each team.
+// Games still tied have no result yet, so they are not counted.
+const standingsQuery = "SELECT g.team_id, g.home, g.result FROM team_games g WHERE g.result IS NOT NULL ORDER BY g.game_date, g.game_id"
+
+// getStandings computes the records of all teams from the results of their games, without ranks and games behind
+func (nba *NBAStatistics) getStandings() ([]StandingRecord, error) {
+	rows, err := nba.db.Query(standingsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the results of the games")
+	}
+	defer rows.Close()
+
+	records := make(map[int]*StandingRecord)
+	for id, team := range nba.teams {
+		records[id] = &StandingRecord{ID: id, Name: team.Name, Conference: team.Conference, Division: team.Division}
+	}
+	for rows.Next() {
+		var (
+			teamID int
+			home   bool
+			result string
+		)
+		if err := rows.Scan(&teamID, &home, &result); err != nil {
+			return nil, err
+		}
+		record, exists := records[teamID]
+		if !exists {
+			continue
+		}
+		record.add(result)
+		if home {
+			record.Home.add(result)
+		} else {
+			record.Away.add(result)
+		}
+		record.results = append(record.results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	standings := []StandingRecord{}
+	for _, record := range records {
+		if games := record.Wins + record.Losses; games > 0 {
+			record.Pct = float64(record.Wins) / float64(games)
+		}
+		for _, result := range record.results[max(len(record.results)-standingsLastGames, 0):] {
+			record.LastTen.add(result)
+		}
+		if n := len(record.results); n > 0 {
+			streak := 1
+			for streak < n && record.results[n-1-streak] == record.results[n-1] {
+				streak++
+			}
+			record.Streak = fmt.Sprintf("%s%d", record.results[n-1], streak)
+		}
+		standings = append(standings, *record)
+	}
+	return standings, nil
+}
+
+// getStandingsData returns the records of all teams as JSON
+func (nba *NBAStatistics) getStandingsData() ([]byte, error) {
+	return nba.cached(EndpointStandings, CacheGames, standingsCacheKey(), false, func() ([]byte, error) {
+		standings, err := nba.getStandings()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(standings)
+	})
+}
+
+// rankStandings orders the teams by win percentage, then wins, and sets their ranks and the games they trail the first team by
+func rankStandings(standings []StandingRecord) {
+	sort.Slice(standings, func(i, j int) bool {
+		if standings[i].Pct != standings[j].Pct {
+			return standings[i].Pct > standings[j].Pct
+		}
+		if standings[i].Wins != standings[j].Wins {
+			return standings[i].Wins > standings[j].Wins
+		}
+		return standings[i].Name < standings[j].Name
+	})
+	for i := range standings {
+		leader := standings[0]
+		standings[i].Rank = i + 1
+		standings[i].GamesBehind = float64(leader.Wins-standings[i].Wins+standings[i].Losses-leader.Losses) / 2
+	}
+}
+
+// GetStandings returns the standings of all teams, or of the teams of the conference or division given
+func (nba *NBAStatistics) GetStandings(w http.ResponseWriter, r *http.Request) {
+	conference, division := r.URL.Query().Get("conference"), r.URL.Query().Get("division")
+	var conferenceExists, divisionExists bool
+	for _, team := range nba.teams {
+		conferenceExists = conferenceExists || team.Conference == conference
+		divisionExists = divisionExists || team.Division == division
+	}
+	if conference != "" && !conferenceExists {
+		http.Error(w, "Invalid conference", http.StatusBadRequest)
+		return
+	}
+	if division != "" && !divisionExists {
+		http.Error(w, "Invalid division", http.StatusBadRequest)
+		return
+	}
+
+	result, err := nba.getStandingsData()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var all []StandingRecord
+	if err := json.Unmarshal(result, &all); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	standings := []StandingRecord{}
+	for _, record := range all {
+		if (conference == "" || record.Conference == conference) && (division == "" || record.Division == division) {
+			standings = append(standings, record)
+		}
+	}
+	rankStandings(standings)
+
+	resultJSON, _ := json.Marshal(standings)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultJSON)
+}
@@ -7,10 +7,13 @@ import (
 type Team struct {
 	ID   int
 	Name string
+	// Conference and Division are empty for teams not assigned to one
+	Conference string
+	Division   string
 }
 
 func GetTeams(db Database) (map[int]Team, error) {
-	rows, err := db.Query("SELECT id, name FROM teams")
+	rows, err := db.Query("SELECT id, name, COALESCE(conference, ''), COALESCE(division, '') FROM teams")
 	if err != nil {
 		return nil, err
 	}
@@ -19,7 +22,7 @@ func GetTeams(db Database) (map[int]Team, error) {
 	teams := make(map[int]Team)
 	for rows.Next() {
 		var team Team
-		if err := rows.Scan(&team.ID, &team.Name); err != nil {
+		if err := rows.Scan(&team.ID, &team.Name, &team.Conference, &team.Division); err != nil {
 			return nil, err
 		}
 		teams[team.ID] = team
@@ -36,10 +39,38 @@ func (t Team) NewAggregatedRecord() *AggregatedRecord {
 	return &AggregatedRecord{ID: t.ID, Name: t.Name}
 }
 
-func (t Team) CacheKey() string {
-	return fmt.Sprintf("team_%d", t.ID)
+func (t Team) CacheKey(f Filter) string {
+	return versionedKey(fmt.Sprintf("team_%d", t.ID) + f.cacheKeySuffix())
 }
 
-func (t Team) DBQuery() string {
-	return fmt.Sprintf(`SELECT AVG(r.points) AS points, AVG(r.rebounds) AS rebounds, AVG(r.assists) AS assists, AVG(r.steals) AS steals, AVG(r.blocks) AS blocks, AVG(r.turnovers) AS turnovers, AVG(r.fouls) AS fouls, AVG(r.minutes) AS minutes FROM records r JOIN players p ON r.player_id = p.id WHERE p.team_id=%d;`, t.ID)
+// A team game is a date on which any of the team players has a record
+func (t Team) recordsQuery(f Filter, args *queryArgs) string {
+	// The subquery repeats the placeholders of the outer conditions
+	from := `FROM records r JOIN players p ON r.player_id = p.id WHERE p.team_id=` + args.add(t.ID) + f.conditions(args)
+	source := `SELECT r.* ` + from
+	if games := f.Window.lastGames(); games > 0 {
+		source += fmt.Sprintf(` AND r.game_date IN (SELECT DISTINCT r.game_date %s ORDER BY r.game_date DESC LIMIT %s)`, from, args.add(games))
+	}
+	return source
+}
+
+func (t Team) DBQuery(f Filter) Query {
+	if f.isAll() {
+		return totalsQuery("team_totals", "team_id", t.ID)
+	}
+	var args queryArgs
+	return aggregateQuery(teamGamesCount, t.recordsQuery(f, &args), args)
+}
+
+// The per game value of a team is the total of its players' records on that date
+func (t Team) SeriesQuery(stat string, games int, f Filter) Query {
+	var args queryArgs
+	source := t.recordsQuery(f, &args)
+	return Query{fmt.Sprintf(`SELECT g.game_date, g.value, AVG(g.value) OVER (ORDER BY g.game_date ROWS BETWEEN %[2]s::int PRECEDING AND CURRENT ROW) AS average FROM (SELECT r.game_date, SUM(r.%[1]s)::float8 AS value FROM (%[3]s) r WHERE r.game_date IS NOT NULL GROUP BY r.game_date) g ORDER BY g.game_date;`, stat, args.add(games-1), source),
+		args}
+}
+
+func (t Team) SplitQuery(dimension string, f Filter) Query {
+	var args queryArgs
+	return splitQuery(dimension, teamGamesCount, t.recordsQuery(f, &args), args)
 }
@@ -0,0 +1,114 @@
+package nba
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ShimonMoldawskiy/NBAStatistics/common"
+)
+
+// aggregateViews are the materialized views of the aggregates of all players and teams
+var aggregateViews = map[string]string{
+	"players": "player_aggregates",
+	"teams":   "team_aggregates",
+}
+
+const aggregateViewColumns = "games, points, rebounds, assists, steals, blocks, turnovers, fouls, minutes, double_doubles, triple_doubles"
+
+// refreshViewsLock is the advisory lock taken while the views are refreshed, so a single pod refreshes them at a time
+const refreshViewsLock = 7531
+
+// RefreshViews refreshes the aggregate views without blocking their readers and records the refresh time.
+// It returns false without refreshing them if another instance is refreshing them already.
+func RefreshViews(db Database) (bool, error) {
+	refreshed := false
+	err := db.InTx(func(tx common.Tx) error {
+		rows, err := tx.Query("SELECT pg_try_advisory_xact_lock($1)", refreshViewsLock)
+		if err != nil {
+			return err
+		}
+		locked := false
+		if rows.Next() {
+			err = rows.Scan(&locked)
+		}
+		rows.Close()
+		if err != nil || !locked {
+			return err
+		}
+
+		for _, view := range aggregateViews {
+			if err := tx.Exec(fmt.Sprintf("REFRESH MATERIALIZED VIEW CONCURRENTLY %s", view)); err != nil {
+				return err
+			}
+			if err := tx.Exec("INSERT INTO aggregate_view_refreshes (view_name, refreshed_at) VALUES ($1, now()) ON CONFLICT (view_name) DO UPDATE SET refreshed_at = EXCLUDED.refreshed_at",
+				view); err != nil {
+				return err
+			}
+		}
+		refreshed = true
+		return nil
+	})
+	return refreshed, err
+}
+
+// parseMaxStaleness reads how stale the aggregates may be to be served from the views, 0 if they must be live
+func parseMaxStaleness(r *http.Request) (time.Duration, error) {
+	maxStalenessStr := r.URL.Query().Get("maxStaleness")
+	if maxStalenessStr == "" {
+		return 0, nil
+	}
+	maxStaleness, err := time.ParseDuration(maxStalenessStr)
+	if err != nil || maxStaleness < 0 {
+		return 0, fmt.Errorf("invalid maxStaleness %q, expected a duration such as 30s or 5m", maxStalenessStr)
+	}
+	return maxStaleness, nil
+}
+
+// getViewAggregates returns the aggregates of all players or teams from their view,
+// and false if the view was last refreshed longer than maxStaleness ago
+func (nba *NBAStatistics) getViewAggregates(kind string, maxStaleness time.Duration) ([]AggregatedRecord, bool, error) {
+	view := aggregateViews[kind]
+	// The age is measured by the clock of the database, which set the refresh time
+	rows, err := nba.db.Query("SELECT refreshed_at, now() FROM aggregate_view_refreshes WHERE view_name=$1", view)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot get refresh time of %s", view)
+	}
+	fresh := false
+	if rows.Next() {
+		var refreshedAt, now time.Time
+		err = rows.Scan(&refreshedAt, &now)
+		fresh = now.Sub(refreshedAt) <= maxStaleness
+	}
+	rows.Close()
+	if err != nil || !fresh {
+		return nil, false, err
+	}
+
+	objects, _ := nba.leaderObjects(kind)
+	rows, err = nba.db.Query(fmt.Sprintf("SELECT id, %s FROM %s ORDER BY id", aggregateViewColumns, view))
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot get data for %s", view)
+	}
+	defer rows.Close()
+
+	records := []AggregatedRecord{}
+	for rows.Next() {
+		var id int
+		aggregate := &AggregatedRecord{}
+		if err := rows.Scan(append([]interface{}{&id}, aggregateFields(aggregate)...)...); err != nil {
+			return nil, false, err
+		}
+		object, exists := objects[id]
+		if !exists {
+			continue
+		}
+		record := object.NewAggregatedRecord()
+		aggregate.ID, aggregate.Name = record.ID, record.Name
+		records = append(records, *aggregate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	return records, true, nil
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert returns a certificate for the common name, self-signed if parent is nil
+func newTestCert(t *testing.T, commonName string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.certPEM(), c.keyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeCert writes the certificate and its key, dated modTime so a rewrite within the same second is detected
+func writeCert(t *testing.T, dir string, cert *testCert, modTime time.Time) (string, string) {
+	t.Helper()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for path, data := range map[string][]byte{certFile: cert.certPEM(), keyFile: cert.keyPEM(t)} {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func servedCommonName(t *testing.T, reloader *CertReloader) string {
+	t.Helper()
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	now := time.Now()
+	certFile, keyFile := writeCert(t, dir, newTestCert(t, "first", ca), now.Add(-time.Minute))
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := servedCommonName(t, reloader); name != "first" {
+		t.Fatalf("served %s, expected first", name)
+	}
+
+	if reloaded, err := reloader.reload(); err != nil || reloaded {
+		t.Errorf("unchanged files should not be reloaded, got %v, %v", reloaded, err)
+	}
+
+	writeCert(t, dir, newTestCert(t, "second", ca), now)
+	if reloaded, err := reloader.reload(); err != nil || !reloaded {
+		t.Fatalf("changed files should be reloaded, got %v, %v", reloaded, err)
+	}
+	if name := servedCommonName(t, reloader); name != "second" {
+		t.Errorf("served %s, expected second", name)
+	}
+
+	// A broken certificate keeps the previous one
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloader.reload(); err == nil {
+		t.Error("expected an error for a broken certificate")
+	}
+	if name := servedCommonName(t, reloader); name != "second" {
+		t.Errorf("served %s, expected second", name)
+	}
+
+	if _, err := NewCertReloader(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Error("expected an error for a missing certificate")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	certFile, keyFile := writeCert(t, dir, newTestCert(t, "localhost", ca), time.Now())
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, ca.certPEM(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.cert)
+	clientCert := newTestCert(t, "internal", ca).tlsCertificate(t)
+	otherCA := newTestCert(t, "other", nil)
+	untrustedCert := newTestCert(t, "untrusted", otherCA).tlsCertificate(t)
+
+	tests := []struct {
+		clientAuth string
+		cert       *tls.Certificate
+		wantOK     bool
+	}{
+		{ClientAuthNone, nil, true},
+		{ClientAuthNone, &untrustedCert, true},
+		{ClientAuthOptional, nil, true},
+		{ClientAuthOptional, &clientCert, true},
+		{ClientAuthOptional, &untrustedCert, false},
+		{ClientAuthRequire, nil, false},
+		{ClientAuthRequire, &clientCert, true},
+		{ClientAuthRequire, &untrustedCert, false},
+	}
+
+	for _, test := range tests {
+		tlsConfig, err := NewTLSConfig(reloader, test.clientAuth, caFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		srv.TLS = tlsConfig
+		srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+		srv.StartTLS()
+
+		clientConfig := &tls.Config{RootCAs: rootCAs, ServerName: "localhost"}
+		if cert := test.cert; cert != nil {
+			// Present the certificate even when it is not signed by the CAs the server accepts
+			clientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return cert, nil }
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if ok := err == nil; ok != test.wantOK {
+			t.Errorf("clientAuth %s with certificate %v: got error %v", test.clientAuth, test.cert != nil, err)
+		}
+		client.CloseIdleConnections()
+		srv.Close()
+	}
+
+	if _, err := NewTLSConfig(reloader, ClientAuthRequire, certFile+".missing"); err == nil {
+		t.Error("expected an error for a missing client CA file")
+	}
+	if _, err := NewTLSConfig(reloader, ClientAuthRequire, keyFile); err == nil {
+		t.Error("expected an error for a client CA file without certificates")
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		httpsAddr, method, target, want string
+	}{
+		{":8443", "GET", "http://example.com/aggregate/player?playerId=1", "https://example.com:8443/aggregate/player?playerId=1"},
+		{":8443", "POST", "http://example.com:8080/record", "https://example.com:8443/record"},
+		{":443", "GET", "http://example.com:80/leaders?stat=points", "https://example.com/leaders?stat=points"},
+		{"0.0.0.0:8443", "GET", "http://[::1]:8080/events", "https://[::1]:8443/events"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		RedirectHandler(test.httpsAddr).ServeHTTP(w, httptest.NewRequest(test.method, test.target, nil))
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != test.want {
+			t.Errorf("%s %s: got %d to %s, expected %d to %s", test.method, test.target, w.Code, w.Header().Get("Location"), http.StatusPermanentRedirect, test.want)
+		}
+	}
+}